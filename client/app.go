@@ -6,6 +6,7 @@ import (
 
 	"github.com/jbowens/muni-display/client/network"
 	"github.com/jbowens/muni-display/client/render"
+	"github.com/jbowens/muni-display/server/core/predictions"
 	"github.com/octavore/naga/service"
 
 	"golang.org/x/mobile/app"
@@ -39,22 +40,62 @@ func (m *Module) draw(glctx gl.Context, sz size.Event, images *glutil.Images) {
 	glctx.ClearColor(1, 1, 1, 1)
 	glctx.Clear(gl.COLOR_BUFFER_BIT)
 
+	now := time.Now()
+	departures := upcoming(serverResponse.Predictions, now)
+
 	var display render.Display
 	display.Loaded = m.loaded
-	if len(serverResponse.Predictions) > 0 {
+	if len(departures) > 0 {
+		next := departures[0]
 		display.NextOK = true
-		display.NextTrainMinutes = serverResponse.Predictions[0].Minutes
+		display.NextTrainMinutes = minutesUntil(next, now)
+		display.NextTrainRouteName = next.RouteName
+		display.NextTrainDirectionName = next.DirectionName
+		display.NextTrainBunched = next.Bunched
 		display.TransitRouteName = fmt.Sprintf("%s (%s)", serverResponse.Stop.Route, serverResponse.Stop.Direction)
-		if len(serverResponse.Predictions) > 1 {
+		if next.RouteName != "" {
+			display.TransitRouteName = fmt.Sprintf("%s (%s)", next.RouteName, serverResponse.Stop.Direction)
+		}
+		if len(departures) > 1 {
+			nextNext := departures[1]
 			display.NextNextOK = true
-			display.NextNextTrainMinutes = serverResponse.Predictions[1].Minutes
+			display.NextNextTrainMinutes = minutesUntil(nextNext, now)
+			display.NextNextTrainRouteName = nextNext.RouteName
+			display.NextNextTrainDirectionName = nextNext.DirectionName
+			display.NextNextTrainBunched = nextNext.Bunched
+			display.NextNextTrainAfterGap = nextNext.GapBefore
+		}
+		if len(departures) > 2 && departures[2].GapBefore {
+			display.GapAfterMinutes = departures[2].HeadwayMinutes
 		}
-		display.UpdatedSecondsAgo = int(time.Now().Sub(serverResponse.LastRefresh).Seconds())
-		display.PredictionSource = serverResponse.Predictions[0].Source
+		display.UpdatedSecondsAgo = int(now.Sub(serverResponse.LastRefresh).Seconds())
+		display.PredictionSource = next.Source
+		display.UpstreamFailing = serverResponse.Status.ConsecutiveFailures > 0
 	}
 	m.Render.Display(display, sz, glctx, images)
 }
 
+// upcoming returns the predictions for trains that haven't departed yet.
+func upcoming(all []predictions.Prediction, now time.Time) []predictions.Prediction {
+	var res []predictions.Prediction
+	for _, p := range all {
+		if p.DepartsAt.IsZero() || !p.DepartsAt.Before(now) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// minutesUntil counts down the minutes until the predicted departure. Older
+// servers don't send an absolute departure time, so fall back to the minutes
+// from the prediction itself.
+func minutesUntil(p predictions.Prediction, now time.Time) int {
+	if p.DepartsAt.IsZero() {
+		return p.Minutes
+	}
+	return int(p.DepartsAt.Sub(now) / time.Minute)
+}
+
 // main is the entry point of the application. This is function gets registered
 // as the main function of the application.
 func (m *Module) main(a app.App) {
@@ -27,11 +27,14 @@ const (
 	lastUpdatedAtFontSize    = 12
 	informationPopupFontSize = 100
 	transitRouteNameFontSize = 36
+	headsignFontSize         = 24
+	headwayFontSize          = 24
 )
 
 var (
 	foreground          = image.White
 	secondaryForeground = image.NewUniform(color.RGBA{0xA6, 0xE3, 0xFA, 0xFF})
+	headwayForeground   = image.NewUniform(color.RGBA{0xFF, 0xC1, 0x07, 0xFF})
 	background          = image.NewUniform(color.RGBA{0x35, 0x67, 0x99, 0xFF})
 	errorBackground     = image.NewUniform(color.RGBA{0x8C, 0x35, 0x1F, 0xFF})
 	loadingBackground   = image.NewUniform(color.RGBA{0x3B, 0x3B, 0x3B, 0xFF})
@@ -39,14 +42,23 @@ var (
 
 // Display encapsulates all of the data that is displayed on the screen.
 type Display struct {
-	Loaded               bool
-	NextOK               bool
-	NextNextOK           bool
-	NextTrainMinutes     int
-	NextNextTrainMinutes int
-	UpdatedSecondsAgo    int
-	PredictionSource     string
-	TransitRouteName     string
+	Loaded                     bool
+	NextOK                     bool
+	NextNextOK                 bool
+	NextTrainMinutes           int
+	NextNextTrainMinutes       int
+	NextTrainRouteName         string
+	NextNextTrainRouteName     string
+	NextTrainDirectionName     string
+	NextNextTrainDirectionName string
+	NextTrainBunched           bool
+	NextNextTrainBunched       bool
+	NextNextTrainAfterGap      bool
+	GapAfterMinutes            int
+	UpdatedSecondsAgo          int
+	UpstreamFailing            bool
+	PredictionSource           string
+	TransitRouteName           string
 }
 
 func (m *Module) Display(display Display, sz size.Event, glctx gl.Context, images *glutil.Images) {
@@ -91,7 +103,7 @@ func (m *Module) render(rgba *image.RGBA, display Display, dimensions image.Poin
 	// First, render the very next train's minutes on the left half of the screen.
 	d := &font.Drawer{
 		Dst: rgba,
-		Src: foreground,
+		Src: minutesForeground(display.NextTrainBunched),
 		Face: truetype.NewFace(m.font, &truetype.Options{
 			Size:    nextTrainFontSize,
 			DPI:     dpi,
@@ -111,11 +123,15 @@ func (m *Module) render(rgba *image.RGBA, display Display, dimensions image.Poin
 		Y: d.Dot.Y,
 	})
 
+	// Render where the next train is headed underneath its time.
+	m.renderHeadsign(rgba, dimensions.X/4, int(4*dimensions.Y/5)+2*headsignFontSize,
+		display.NextTrainRouteName, display.NextTrainDirectionName)
+
 	// Now, render the next next train's minutes on the right half of the screen.
 	if display.NextNextOK {
 		d = &font.Drawer{
 			Dst: rgba,
-			Src: foreground,
+			Src: minutesForeground(display.NextNextTrainBunched || display.NextNextTrainAfterGap),
 			Face: truetype.NewFace(m.font, &truetype.Options{
 				Size:    nextNextTrainFontSize,
 				DPI:     dpi,
@@ -134,6 +150,26 @@ func (m *Module) render(rgba *image.RGBA, display Display, dimensions image.Poin
 			X: d.Dot.X,
 			Y: d.Dot.Y,
 		})
+
+		// Render where the next, next train is headed underneath its time.
+		m.renderHeadsign(rgba, 3*dimensions.X/4, int(2*dimensions.Y/3)+2*headsignFontSize,
+			display.NextNextTrainRouteName, display.NextNextTrainDirectionName)
+
+		// Warn about bunching and gaps in service, which decide whether the
+		// next train is worth running for.
+		var warnings []string
+		if display.NextTrainBunched && display.NextNextTrainBunched {
+			warnings = append(warnings, "Bunched")
+		} else if display.NextNextTrainAfterGap {
+			warnings = append(warnings, "Long gap before this one")
+		}
+		if display.GapAfterMinutes > 0 {
+			warnings = append(warnings, fmt.Sprintf("Then a %d min gap", display.GapAfterMinutes))
+		}
+		if len(warnings) > 0 {
+			m.renderHeadwayWarning(rgba, 3*dimensions.X/4, int(2*dimensions.Y/3)+4*headsignFontSize,
+				strings.Join(warnings, ". "))
+		}
 	}
 
 	// Render the text indicating the freshness of the presented data.
@@ -147,6 +183,12 @@ func (m *Module) render(rgba *image.RGBA, display Display, dimensions image.Poin
 		}),
 	}
 	updatedAt := fmt.Sprintf("Predictions accurate as of %v seconds ago, from %s.", display.UpdatedSecondsAgo, display.PredictionSource)
+	if display.UpdatedSecondsAgo >= 120 {
+		updatedAt = fmt.Sprintf("Predictions are %v minutes old, from %s.", display.UpdatedSecondsAgo/60, display.PredictionSource)
+	}
+	if display.UpstreamFailing {
+		updatedAt += " Upstream failing."
+	}
 	textWidth = d.MeasureString(updatedAt)
 	d.Dot = fixed.Point26_6{
 		X: fixed.I(dimensions.X-10) - textWidth,
@@ -169,6 +211,65 @@ func (m *Module) renderMin(rgba *image.RGBA, position fixed.Point26_6) {
 	d.DrawString("min")
 }
 
+// renderHeadsign will render the route and direction of a train centered
+// horizontally on x.
+func (m *Module) renderHeadsign(rgba *image.RGBA, x, y int, routeName, directionName string) {
+	var parts []string
+	for _, s := range []string{routeName, directionName} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 {
+		return
+	}
+	text := strings.Join(parts, ": ")
+
+	d := &font.Drawer{
+		Dst: rgba,
+		Src: secondaryForeground,
+		Face: truetype.NewFace(m.font, &truetype.Options{
+			Size:    headsignFontSize,
+			DPI:     dpi,
+			Hinting: font.HintingNone,
+		}),
+	}
+	textWidth := d.MeasureString(text)
+	d.Dot = fixed.Point26_6{
+		X: fixed.I(x) - (textWidth / 2),
+		Y: fixed.I(y),
+	}
+	d.DrawString(text)
+}
+
+// minutesForeground returns the color to draw a train's minutes in.
+func minutesForeground(highlight bool) image.Image {
+	if highlight {
+		return headwayForeground
+	}
+	return foreground
+}
+
+// renderHeadwayWarning will render a warning about bunching or gaps in service
+// centered horizontally on x.
+func (m *Module) renderHeadwayWarning(rgba *image.RGBA, x, y int, text string) {
+	d := &font.Drawer{
+		Dst: rgba,
+		Src: headwayForeground,
+		Face: truetype.NewFace(m.font, &truetype.Options{
+			Size:    headwayFontSize,
+			DPI:     dpi,
+			Hinting: font.HintingNone,
+		}),
+	}
+	textWidth := d.MeasureString(text)
+	d.Dot = fixed.Point26_6{
+		X: fixed.I(x) - (textWidth / 2),
+		Y: fixed.I(y),
+	}
+	d.DrawString(text)
+}
+
 // renderLoadingScreen will render the Loading screen.
 func (m *Module) renderInformation(rgba *image.RGBA, dimensions image.Point, background image.Image, text string, textSizing string) {
 	// Prepare a dark grey background to draw on.
@@ -0,0 +1,30 @@
+package http
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/jbowens/muni-display/server/core/predictions"
+)
+
+type HandleAccuracyResponse struct {
+	Stop     predictions.Stop         `json:"stop"`
+	Accuracy predictions.StopAccuracy `json:"accuracy"`
+}
+
+// handleAccuracy responds with how far the stop's departures have been from
+// what was predicted 5, 10 and 15 minutes beforehand, overall and by hour of
+// the day.
+func (m *Module) handleAccuracy(rw http.ResponseWriter, req *http.Request) {
+	stopKey := filepath.Base(req.URL.Path)
+	stop, ok := m.Predictions.Stop(stopKey)
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	m.writeJSON(rw, HandleAccuracyResponse{
+		Stop:     stop,
+		Accuracy: m.Predictions.Accuracy(stopKey),
+	})
+}
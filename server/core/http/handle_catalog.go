@@ -0,0 +1,124 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jbowens/muni-display/server/core/predictions"
+)
+
+type HandleAgenciesResponse struct {
+	Agencies []predictions.AgencyInfo `json:"agencies"`
+}
+
+type HandleRoutesResponse struct {
+	Agency string                  `json:"agency"`
+	Routes []predictions.RouteInfo `json:"routes"`
+}
+
+type HandleRouteStopsResponse struct {
+	Route     predictions.RouteInfo  `json:"route"`
+	Direction string                 `json:"direction,omitempty"`
+	Stops     []predictions.StopInfo `json:"stops"`
+}
+
+// pathSegments splits the request's path into its segments.
+func pathSegments(req *http.Request) []string {
+	return strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+}
+
+// catalogErrorStatus returns the status code to respond with when the stop
+// catalog fails. Only failures of 511.org itself are a 502.
+func catalogErrorStatus(err error) int {
+	var ambiguous *predictions.AmbiguousRouteError
+	var upstream *predictions.UpstreamError
+	switch {
+	case errors.As(err, &ambiguous), errors.Is(err, predictions.ErrEmptyQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, predictions.ErrNoCatalog), errors.Is(err, predictions.ErrCatalogBudget),
+		errors.Is(err, predictions.ErrCatalogTooLarge):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &upstream):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// handleAgencies responds with every agency at /agencies, and with an
+// agency's routes at /agencies/{agency}/routes.
+func (m *Module) handleAgencies(rw http.ResponseWriter, req *http.Request) {
+	segments := pathSegments(req)
+	switch {
+	case len(segments) == 1:
+		agencies, err := m.Predictions.Agencies(req.Context())
+		if err != nil {
+			http.Error(rw, err.Error(), catalogErrorStatus(err))
+			return
+		}
+		m.writeJSON(rw, HandleAgenciesResponse{Agencies: agencies})
+	case len(segments) == 3 && segments[2] == "routes":
+		routes, err := m.Predictions.Routes(req.Context(), segments[1])
+		if err != nil {
+			http.Error(rw, err.Error(), catalogErrorStatus(err))
+			return
+		}
+		if len(routes) == 0 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		m.writeJSON(rw, HandleRoutesResponse{Agency: segments[1], Routes: routes})
+	default:
+		rw.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleRouteStops responds with the stops of a route in a direction at
+// /routes/{route}/directions/{direction}/stops. The "agency" query parameter
+// picks the agency if several run a route with the same code. For agencies
+// that don't distinguish directions, the direction is ignored.
+func (m *Module) handleRouteStops(rw http.ResponseWriter, req *http.Request) {
+	segments := pathSegments(req)
+	if len(segments) != 5 || segments[2] != "directions" || segments[4] != "stops" {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	code, direction := segments[1], segments[3]
+
+	route, ok, err := m.Predictions.Route(req.Context(), req.URL.Query().Get("agency"), code)
+	if err != nil {
+		http.Error(rw, err.Error(), catalogErrorStatus(err))
+		return
+	}
+	if !ok {
+		http.Error(rw, fmt.Sprintf("No route %q", code), http.StatusNotFound)
+		return
+	}
+
+	if len(route.Directions) == 0 {
+		direction = ""
+	} else {
+		var found bool
+		for _, d := range route.Directions {
+			if strings.EqualFold(d.Code, direction) {
+				direction, found = d.Code, true
+			}
+		}
+		if !found {
+			http.Error(rw, fmt.Sprintf("Route %q has no direction %q", code, direction), http.StatusNotFound)
+			return
+		}
+	}
+
+	stops, err := m.Predictions.RouteStops(req.Context(), route.Agency, route.Code, direction)
+	if err != nil {
+		http.Error(rw, err.Error(), catalogErrorStatus(err))
+		return
+	}
+	m.writeJSON(rw, HandleRouteStopsResponse{
+		Route:     route,
+		Direction: direction,
+		Stops:     stops,
+	})
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newCatalogServer stands in for the 511.org catalog endpoints. SF-MUNI and
+// AC Transit both run an "N", and BART's routes fail to load.
+func newCatalogServer(t *testing.T) *httptest.Server {
+	stops, err := ioutil.ReadFile(filepath.Join(fixturesDir, "stops.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch req.URL.Path + "?" + query.Get("agencyName") + query.Get("routeIDF") {
+		case "/GetAgencies.aspx?":
+			rw.Write([]byte(`<RTT><AgencyList>
+<Agency Name="AC Transit" HasDirection="False" Mode="Bus" />
+<Agency Name="BART" HasDirection="False" Mode="Rail" />
+<Agency Name="SF-MUNI" HasDirection="True" Mode="Bus" />
+</AgencyList></RTT>`))
+		case "/GetRoutesForAgency.aspx?SF-MUNI":
+			rw.Write([]byte(`<RTT><AgencyList><Agency Name="SF-MUNI" HasDirection="True" Mode="Bus"><RouteList>
+<Route Name="N-Judah" Code="N"><RouteDirectionList>
+<RouteDirection Code="Inbound" Name="Inbound to Caltrain via Downtown" />
+</RouteDirectionList></Route>
+</RouteList></Agency></AgencyList></RTT>`))
+		case "/GetRoutesForAgency.aspx?AC Transit":
+			rw.Write([]byte(`<RTT><AgencyList><Agency Name="AC Transit" HasDirection="False" Mode="Bus"><RouteList>
+<Route Name="N" Code="N" />
+</RouteList></Agency></AgencyList></RTT>`))
+		case "/GetRoutesForAgency.aspx?BART":
+			http.Error(rw, "unavailable", http.StatusInternalServerError)
+		case "/GetStopsForRoute.aspx?SF-MUNI~N~Inbound":
+			rw.Write(stops)
+		default:
+			rw.Write([]byte(`<RTT><AgencyList></AgencyList></RTT>`))
+		}
+	}))
+}
+
+func TestCatalogEndpoints(t *testing.T) {
+	upstream := newCatalogServer(t)
+	defer upstream.Close()
+	m, cleanup := newTestModule(t, map[string]interface{}{
+		"config.json": map[string]interface{}{
+			"timezone": "UTC",
+			"catalog":  map[string]interface{}{"url": upstream.URL, "agencies": []string{"SF-MUNI", "AC Transit"}},
+		},
+		"keys.json":  map[string]string{"511.org": "secret"},
+		"stops.json": map[string]interface{}{},
+	})
+	defer cleanup()
+
+	var agencies HandleAgenciesResponse
+	if code := get(t, m, "/agencies", &agencies); code != http.StatusOK || len(agencies.Agencies) != 3 {
+		t.Errorf("got status %d and %d agencies", code, len(agencies.Agencies))
+	}
+
+	var routes HandleRoutesResponse
+	if code := get(t, m, "/agencies/SF-MUNI/routes", &routes); code != http.StatusOK || len(routes.Routes) != 1 {
+		t.Errorf("got status %d and %d routes", code, len(routes.Routes))
+	}
+
+	var stops HandleRouteStopsResponse
+	if code := get(t, m, "/routes/N/directions/inbound/stops?agency=SF-MUNI", &stops); code != http.StatusOK {
+		t.Fatalf("got status %d for the N's stops", code)
+	}
+	if stops.Direction != "Inbound" || len(stops.Stops) != 33 || stops.Stops[0].Code != 13212 {
+		t.Errorf("unexpected response %+v", stops)
+	}
+
+	testCases := []struct {
+		path string
+		code int
+	}{
+		// Several agencies run an N.
+		{"/routes/N/directions/inbound/stops", http.StatusBadRequest},
+		// 511.org fails to list BART's routes.
+		{"/routes/RICH/directions/south/stops?agency=BART", http.StatusBadGateway},
+		{"/agencies/BART/routes", http.StatusBadGateway},
+		// A query without any words.
+		{"/stops/search?q=%21%21%21", http.StatusBadRequest},
+		{"/agencies/Muni/routes", http.StatusNotFound},
+		{"/routes/J/directions/inbound/stops?agency=SF-MUNI", http.StatusNotFound},
+		{"/routes/N/directions/outbound/stops?agency=SF-MUNI", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		if code := get(t, m, tc.path, nil); code != tc.code {
+			t.Errorf("%s: got status %d, expected %d", tc.path, code, tc.code)
+		}
+	}
+}
+
+func TestCatalogEndpointsWithoutToken(t *testing.T) {
+	m, cleanup := newTestModule(t, map[string]interface{}{
+		"config.json": map[string]interface{}{"timezone": "UTC"},
+		"keys.json":   map[string]string{},
+		"stops.json":  map[string]interface{}{},
+	})
+	defer cleanup()
+
+	for _, path := range []string{"/agencies", "/agencies/SF-MUNI/routes", "/routes/N/directions/inbound/stops", "/stops/search?q=judah"} {
+		if code := get(t, m, path, nil); code != http.StatusServiceUnavailable {
+			t.Errorf("%s: got status %d, expected %d", path, code, http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/jbowens/muni-display/server/core/predictions"
+)
+
+const (
+	defaultHistoryRange = time.Hour
+)
+
+type HandleHistoryResponse struct {
+	Stop    predictions.Stop           `json:"stop"`
+	From    time.Time                  `json:"from"`
+	To      time.Time                  `json:"to"`
+	History []predictions.HistoryEntry `json:"history"`
+}
+
+// handleHistory responds with the predictions for a stop from every refresh
+// between the "from" and "to" query parameters, formatted as RFC 3339. They
+// default to the last hour. It responds with a 404 if the history isn't
+// enabled.
+func (m *Module) handleHistory(rw http.ResponseWriter, req *http.Request) {
+	stopKey := filepath.Base(req.URL.Path)
+	stop, ok := m.Predictions.Stop(stopKey)
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	to := time.Now()
+	if s := req.URL.Query().Get("to"); s != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(rw, "Invalid to time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	from := to.Add(-defaultHistoryRange)
+	if s := req.URL.Query().Get("from"); s != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(rw, "Invalid from time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if from.After(to) {
+		http.Error(rw, "The from time is after the to time", http.StatusBadRequest)
+		return
+	}
+
+	history, err := m.Predictions.History(stopKey, from, to)
+	if errors.Is(err, predictions.ErrHistoryDisabled) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.writeJSON(rw, HandleHistoryResponse{
+		Stop:    stop,
+		From:    from,
+		To:      to,
+		History: history,
+	})
+}
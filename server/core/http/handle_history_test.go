@@ -0,0 +1,66 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHistoryDisabled(t *testing.T) {
+	m, cleanup := newTestModule(t, map[string]interface{}{
+		"config.json": map[string]interface{}{"timezone": "UTC"},
+		"keys.json":   map[string]string{"511.org": "secret"},
+		"stops.json": map[string]interface{}{
+			"judah": map[string]interface{}{"name": "Judah St and 22nd Ave", "route": "N", "code": 15201},
+		},
+	})
+	defer cleanup()
+
+	if code := get(t, m, "/history/judah", nil); code != http.StatusNotFound {
+		t.Errorf("got status %d without a history, expected %d", code, http.StatusNotFound)
+	}
+}
+
+func TestHistoryRange(t *testing.T) {
+	upstream := newFixtureServer(t)
+	defer upstream.Close()
+
+	m, cleanup := newTestModule(t, map[string]interface{}{
+		"config.json": map[string]interface{}{
+			"timezone": "UTC",
+			"history":  map[string]string{"path": "history.db"},
+			"sources": map[string]interface{}{
+				"511.org": map[string]string{"url": upstream.URL + "/GetNextDeparturesByStopCode.aspx"},
+			},
+		},
+		"keys.json": map[string]string{"511.org": "secret"},
+		"stops.json": map[string]interface{}{
+			"judah": map[string]interface{}{"name": "Judah St and 22nd Ave", "route": "N", "code": 15201},
+		},
+	})
+	defer cleanup()
+	if code := get(t, m, "/predictions/judah", nil); code != http.StatusOK {
+		t.Fatalf("got status %d for /predictions/judah", code)
+	}
+
+	testCases := []struct {
+		query   string
+		code    int
+		entries int
+	}{
+		{"", http.StatusOK, 1},
+		{"?from=0001-01-01T00:00:00Z&to=9999-12-31T23:59:59Z", http.StatusOK, 1},
+		{"?from=0001-01-01T00:00:00Z&to=1900-01-01T00:00:00Z", http.StatusOK, 0},
+		{"?from=2016-12-01T09:00:00Z&to=2016-12-01T08:00:00Z", http.StatusBadRequest, 0},
+		{"?from=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tc := range testCases {
+		var response HandleHistoryResponse
+		if code := get(t, m, "/history/judah"+tc.query, &response); code != tc.code {
+			t.Errorf("%q: got status %d, expected %d", tc.query, code, tc.code)
+			continue
+		}
+		if len(response.History) != tc.entries {
+			t.Errorf("%q: got %d entries, expected %d", tc.query, len(response.History), tc.entries)
+		}
+	}
+}
@@ -14,24 +14,27 @@ import (
 type HandlePredictionsResponse struct {
 	LastRefresh time.Time                `json:"last_refresh"`
 	Stop        predictions.Stop         `json:"stop"`
+	Status      predictions.StopStatus   `json:"status"`
+	Headway     predictions.Headway      `json:"headway"`
 	Predictions []predictions.Prediction `json:"predictions"`
 }
 
 func (m *Module) handlePredictions(rw http.ResponseWriter, req *http.Request) {
-	var zeroStop predictions.Stop
-
 	stopKey := filepath.Base(req.URL.Path)
-	stop := m.Predictions.Stop(stopKey)
-	if stop == zeroStop {
+	stop, ok := m.Predictions.Stop(stopKey)
+	if !ok {
 		rw.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	lastRefreshed := m.Predictions.LastUpdated()
+	m.Predictions.Request(stopKey)
+	status := m.Predictions.Status(stopKey)
 	predictions := m.Predictions.Current(stopKey)
 	m.writeJSON(rw, HandlePredictionsResponse{
-		LastRefresh: lastRefreshed,
+		LastRefresh: status.LastSuccess,
 		Stop:        stop,
+		Status:      status,
+		Headway:     m.Predictions.Headway(stopKey),
 		Predictions: predictions,
 	})
 }
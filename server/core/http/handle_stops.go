@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/jbowens/muni-display/server/core/predictions"
+)
+
+type HandleStopSearchResponse struct {
+	Query string                 `json:"query"`
+	Stops []predictions.StopInfo `json:"stops"`
+}
+
+// handleStopSearch responds with the 511.org stops that match the "q" query
+// parameter, ex. "judah 22nd", best matches first. "limit" bounds how many
+// are returned.
+func (m *Module) handleStopSearch(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query().Get("q")
+	if query == "" {
+		http.Error(rw, "Missing q query parameter", http.StatusBadRequest)
+		return
+	}
+	var limit int
+	if s := req.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			http.Error(rw, "Invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	stops, err := m.Predictions.SearchStops(req.Context(), query, limit)
+	if err != nil {
+		http.Error(rw, err.Error(), catalogErrorStatus(err))
+		return
+	}
+	m.writeJSON(rw, HandleStopSearchResponse{
+		Query: query,
+		Stops: stops,
+	})
+}
@@ -33,6 +33,12 @@ func (m *Module) setup() error {
 
 	m.mux = http.NewServeMux()
 	m.mux.HandleFunc("/predictions/", m.handlePredictions)
+	m.mux.HandleFunc("/history/", m.handleHistory)
+	m.mux.HandleFunc("/accuracy/", m.handleAccuracy)
+	m.mux.HandleFunc("/stops/search", m.handleStopSearch)
+	m.mux.HandleFunc("/agencies", m.handleAgencies)
+	m.mux.HandleFunc("/agencies/", m.handleAgencies)
+	m.mux.HandleFunc("/routes/", m.handleRouteStops)
 	return nil
 }
 
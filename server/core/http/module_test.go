@@ -0,0 +1,251 @@
+package http
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jbowens/muni-display/server/core/config"
+	"github.com/jbowens/muni-display/server/core/predictions"
+	"github.com/octavore/naga/service"
+)
+
+// fixtureServer stands in for 511.org, answering each endpoint with the
+// matching response in server/fixtures. Requests for the stop codes in
+// failing fail with a 500.
+type fixtureServer struct {
+	*httptest.Server
+	t       *testing.T
+	failing map[string]bool
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+var fixtureFiles = map[string]string{
+	"/GetNextDeparturesByStopCode.aspx": "next_departures.xml",
+}
+
+func newFixtureServer(t *testing.T) *fixtureServer {
+	s := &fixtureServer{t: t, failing: make(map[string]bool)}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *fixtureServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	name, ok := fixtureFiles[req.URL.Path]
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	if s.failing[req.URL.Query().Get("stopCode")] {
+		http.Error(rw, "unavailable", http.StatusInternalServerError)
+		return
+	}
+	b, err := ioutil.ReadFile(filepath.Join(fixturesDir, name))
+	if err != nil {
+		s.t.Error(err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Write(b)
+}
+
+func (s *fixtureServer) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request{}, s.requests...)
+}
+
+var fixturesDir, _ = filepath.Abs("../../fixtures")
+
+// newTestModule sets up the HTTP and predictions modules with the given config
+// files, run from a temporary directory. It returns the HTTP module and a
+// function that stops both and restores the working directory.
+func newTestModule(t *testing.T, files map[string]interface{}) (*Module, func()) {
+	dir, err := ioutil.TempDir("", "muni-display")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		b, err := json.Marshal(contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "config", name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	var predictionsService, httpService service.Config
+	p := &predictions.Module{Config: &config.Module{}}
+	p.Init(&predictionsService)
+	m := &Module{Config: &config.Module{}, Predictions: p}
+	m.Init(&httpService)
+	cleanup := func() {
+		if predictionsService.Stop != nil {
+			predictionsService.Stop()
+		}
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+	if err := predictionsService.Setup(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err := httpService.Setup(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return m, cleanup
+}
+
+func get(t *testing.T, handler http.Handler, path string, dst interface{}) int {
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, nil))
+	if rw.Code == http.StatusOK && dst != nil {
+		if err := json.Unmarshal(rw.Body.Bytes(), dst); err != nil {
+			t.Fatalf("GET %s: %s", path, err)
+		}
+	}
+	return rw.Code
+}
+
+func TestPredictionsFrom511(t *testing.T) {
+	upstream := newFixtureServer(t)
+	defer upstream.Close()
+	upstream.failing["99999"] = true
+
+	m, cleanup := newTestModule(t, map[string]interface{}{
+		"config.json": map[string]interface{}{
+			"timezone": "UTC",
+			"upstream": map[string]string{"user_agent": "muni-display-test"},
+			"sources": map[string]interface{}{
+				"511.org": map[string]string{"url": upstream.URL + "/GetNextDeparturesByStopCode.aspx"},
+			},
+		},
+		"keys.json": map[string]string{"511.org": "secret"},
+		"stops.json": map[string]interface{}{
+			"judah":    map[string]interface{}{"name": "Judah St and 22nd Ave", "route": "N", "direction": "Inbound", "code": 15201},
+			"outbound": map[string]interface{}{"name": "Judah St and 22nd Ave", "route": "N", "direction": "Outbound", "code": 15201},
+			"owl":      map[string]interface{}{"name": "Judah St and 22nd Ave", "route": "N_OWL", "code": 15201},
+			"broken":   map[string]interface{}{"name": "Nowhere", "route": "N", "code": 99999},
+		},
+	})
+	defer cleanup()
+
+	var response HandlePredictionsResponse
+	if code := get(t, m, "/predictions/judah", &response); code != http.StatusOK {
+		t.Fatalf("got status %d for /predictions/judah", code)
+	}
+	var minutes []int
+	for _, p := range response.Predictions {
+		minutes = append(minutes, p.Minutes)
+		if p.RouteCode != "N" || p.DirectionCode != "Inbound" || p.Source != "511.org" {
+			t.Errorf("unexpected prediction %+v", p)
+		}
+	}
+	if len(minutes) != 3 || minutes[0] != 2 || minutes[1] != 7 || minutes[2] != 14 {
+		t.Errorf("got minutes %v, expected [2 7 14]", minutes)
+	}
+	if response.Stop.Code != 15201 || response.LastRefresh.IsZero() || response.Status.LastError != "" {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	requests := upstream.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d upstream requests, expected 1", len(requests))
+	}
+	query := requests[0].URL.Query()
+	if query.Get("token") != "secret" || query.Get("stopCode") != "15201" {
+		t.Errorf("unexpected upstream query %q", requests[0].URL.RawQuery)
+	}
+	if ua := requests[0].Header.Get("User-Agent"); ua != "muni-display-test" {
+		t.Errorf("got user agent %q, expected muni-display-test", ua)
+	}
+
+	// The stop was just refreshed, so asking again doesn't go upstream.
+	if code := get(t, m, "/predictions/judah", &response); code != http.StatusOK || len(response.Predictions) != 3 {
+		t.Errorf("got status %d and %d predictions on the second request", code, len(response.Predictions))
+	}
+	if n := len(upstream.Requests()); n != 1 {
+		t.Errorf("got %d upstream requests, expected 1", n)
+	}
+
+	for _, key := range []string{"outbound", "owl"} {
+		response = HandlePredictionsResponse{}
+		if code := get(t, m, "/predictions/"+key, &response); code != http.StatusOK {
+			t.Fatalf("got status %d for /predictions/%s", code, key)
+		}
+		if len(response.Predictions) != 0 || response.LastRefresh.IsZero() {
+			t.Errorf("%s: got %d predictions, last refreshed %s", key, len(response.Predictions), response.LastRefresh)
+		}
+	}
+
+	response = HandlePredictionsResponse{}
+	if code := get(t, m, "/predictions/broken", &response); code != http.StatusOK {
+		t.Fatalf("got status %d for /predictions/broken", code)
+	}
+	if !response.LastRefresh.IsZero() || response.Status.ConsecutiveFailures != 1 ||
+		!strings.Contains(response.Status.LastError, "500") {
+		t.Errorf("unexpected status %+v", response.Status)
+	}
+
+	if code := get(t, m, "/predictions/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown stop, expected 404", code)
+	}
+}
+
+func TestPredictionsFromFallback(t *testing.T) {
+	upstream := newFixtureServer(t)
+	defer upstream.Close()
+
+	m, cleanup := newTestModule(t, map[string]interface{}{
+		"config.json": map[string]interface{}{
+			"timezone": "UTC",
+			"sources": map[string]interface{}{
+				"511.org:down":  map[string]string{"url": upstream.URL + "/missing"},
+				"511.org:muni":  map[string]string{"url": upstream.URL + "/GetNextDeparturesByStopCode.aspx"},
+				"fallback:muni": map[string]interface{}{"sources": []string{"511.org:down", "511.org:muni"}},
+			},
+		},
+		"keys.json": map[string]string{"511.org": "secret"},
+		"stops.json": map[string]interface{}{
+			"judah": map[string]interface{}{"name": "Judah St and 22nd Ave", "route": "N", "direction": "Inbound", "code": 15201, "source": "fallback:muni"},
+		},
+	})
+	defer cleanup()
+
+	var response HandlePredictionsResponse
+	if code := get(t, m, "/predictions/judah", &response); code != http.StatusOK {
+		t.Fatalf("got status %d for /predictions/judah", code)
+	}
+	if len(response.Predictions) != 3 || response.Status.ActiveSource != "511.org:muni" {
+		t.Errorf("got %d predictions from %q, expected 3 from 511.org:muni", len(response.Predictions), response.Status.ActiveSource)
+	}
+	for _, p := range response.Predictions {
+		if p.Source != "511.org:muni" {
+			t.Errorf("got source %q, expected 511.org:muni", p.Source)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package predictions
 
 import (
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
@@ -11,13 +13,28 @@ import (
 )
 
 const (
-	serviceURL = "http://services.my511.org/Transit2.0/GetNextDeparturesByStopCode.aspx?token=&stopCode="
+	default511URL = "http://services.my511.org/Transit2.0/GetNextDeparturesByStopCode.aspx?token=&stopCode="
 )
 
+type departureStop struct {
+	Name          string `xml:"name,attr"`
+	Code          int    `xml:"StopCode,attr"`
+	DepartureTime []int  `xml:"DepartureTimeList>DepartureTime"`
+}
+
+type routeDirection struct {
+	Name  string          `xml:"Name,attr"`
+	Code  string          `xml:"Code,attr"`
+	Stops []departureStop `xml:"StopList>Stop"`
+}
+
 type route struct {
-	Name          string `xml:"Name,attr"`
-	Code          string `xml:"Code,attr"`
-	DepartureTime []int  `xml:"RouteDirectionList>RouteDirection>StopList>Stop>DepartureTimeList>DepartureTime"`
+	Name       string           `xml:"Name,attr"`
+	Code       string           `xml:"Code,attr"`
+	Directions []routeDirection `xml:"RouteDirectionList>RouteDirection"`
+	// Stops is only populated for agencies that don't distinguish directions
+	// (ex. BART).
+	Stops []departureStop `xml:"StopList>Stop"`
 }
 
 type nextDeparaturesResponse struct {
@@ -25,23 +42,48 @@ type nextDeparaturesResponse struct {
 }
 
 type defaultPredictor struct {
-	accessToken string
+	serviceURL    string
+	client        *upstreamClient
+	accessToken   string
+	routeVariants routeVariants
 }
 
 var _ Predictor = &defaultPredictor{}
 
-func (d defaultPredictor) Predict(stop *Stop) ([]Prediction, error) {
-	l, err := url.Parse(serviceURL)
+// new511Predictor builds a predictor for the legacy 511.org API. The access
+// token is the one named by the "key" option, which defaults to "511.org".
+// The "url" option points it at another server, ex. a proxy or a local
+// stand-in for the API.
+func new511Predictor(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	o := legacy511Config{URL: default511URL, Key: "511.org"}
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	token, err := c.token(o.Key)
+	if err != nil {
+		return nil, err
+	}
+	c.consumes(o.Key)
+	return &defaultPredictor{
+		serviceURL:    o.URL,
+		client:        c.client(o.upstreamConfig),
+		accessToken:   token,
+		routeVariants: c.routeVariants,
+	}, nil
+}
+
+func (d defaultPredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	l, err := url.Parse(d.serviceURL)
 	if err != nil {
 		return nil, err
 	}
 
-	queryParams := url.Values{}
-	queryParams.Add("token", d.accessToken)
-	queryParams.Add("stopCode", strconv.Itoa(int(stop.Code)))
+	queryParams := l.Query()
+	queryParams.Set("token", d.accessToken)
+	queryParams.Set("stopCode", strconv.Itoa(int(stop.Code)))
 	l.RawQuery = queryParams.Encode()
 
-	resp, err := http.DefaultClient.Post(l.String(), "", nil)
+	resp, err := d.client.do(ctx, http.MethodPost, l.String())
 	if err != nil {
 		return nil, err
 	}
@@ -59,17 +101,44 @@ func (d defaultPredictor) Predict(stop *Stop) ([]Prediction, error) {
 	if err := xml.Unmarshal(b, &response); err != nil {
 		return nil, err
 	}
+	return d.parse(&response, stop, clock(ctx)), nil
+}
 
+// parse extracts the departures from the response that belong to the stop's
+// route and direction.
+func (d defaultPredictor) parse(response *nextDeparaturesResponse, stop *Stop, now time.Time) []Prediction {
 	var predictions []Prediction
+	addStops := func(r route, direction routeDirection, stops []departureStop) {
+		for _, s := range stops {
+			if s.Code != stop.Code {
+				continue
+			}
+			for _, minutes := range s.DepartureTime {
+				predictions = append(predictions, Prediction{
+					CreatedAt:     now,
+					Minutes:       minutes,
+					Stop:          stop,
+					Source:        source511,
+					RouteCode:     r.Code,
+					RouteName:     r.Name,
+					DirectionCode: direction.Code,
+					DirectionName: direction.Name,
+				})
+			}
+		}
+	}
+
 	for _, route := range response.Routes {
-		for _, minutes := range route.DepartureTime {
-			predictions = append(predictions, Prediction{
-				CreatedAt: time.Now(),
-				Minutes:   minutes,
-				Stop:      stop,
-				Source:    "511.org",
-			})
+		if !d.routeVariants.matches(stop, route.Code) {
+			continue
+		}
+		addStops(route, routeDirection{}, route.Stops)
+		for _, direction := range route.Directions {
+			if !matchesDirection(stop, direction.Code) {
+				continue
+			}
+			addStops(route, direction, direction.Stops)
 		}
 	}
-	return predictions, nil
+	return predictions
 }
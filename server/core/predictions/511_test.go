@@ -0,0 +1,148 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadNextDepartures(t *testing.T) *nextDeparaturesResponse {
+	b, err := ioutil.ReadFile("../../fixtures/next_departures.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response nextDeparaturesResponse
+	if err := xml.Unmarshal(b, &response); err != nil {
+		t.Fatal(err)
+	}
+	return &response
+}
+
+func TestParseNextDepartures(t *testing.T) {
+	response := loadNextDepartures(t)
+	// The fixture's N_OWL stop has no departures; give it one so that the
+	// variant mapping has something to include.
+	owl := response.Routes[1]
+	if owl.Code != "N_OWL" {
+		t.Fatalf("unexpected fixture route %q", owl.Code)
+	}
+	withOwl := &nextDeparaturesResponse{Routes: append([]route{}, response.Routes...)}
+	withOwl.Routes[1].Directions = []routeDirection{owl.Directions[0]}
+	withOwl.Routes[1].Directions[0].Stops = []departureStop{owl.Directions[0].Stops[0]}
+	withOwl.Routes[1].Directions[0].Stops[0].DepartureTime = []int{31}
+
+	variants := routeVariants{"N": {"N_OWL"}}
+	testCases := []struct {
+		name     string
+		response *nextDeparaturesResponse
+		variants routeVariants
+		stop     Stop
+		routes   []string
+		minutes  []int
+	}{
+		{"any route", response, nil, Stop{Code: 15201}, []string{"N", "N", "N"}, []int{2, 7, 14}},
+		{"N", response, nil, Stop{Code: 15201, Route: "N"}, []string{"N", "N", "N"}, []int{2, 7, 14}},
+		{"N lowercase", response, nil, Stop{Code: 15201, Route: "n"}, []string{"N", "N", "N"}, []int{2, 7, 14}},
+		{"N_OWL without departures", response, variants, Stop{Code: 15201, Route: "N_OWL"}, nil, nil},
+		{"N_OWL", withOwl, nil, Stop{Code: 15201, Route: "N_OWL"}, []string{"N_OWL"}, []int{31}},
+		{"N without variants", withOwl, nil, Stop{Code: 15201, Route: "N"}, []string{"N", "N", "N"}, []int{2, 7, 14}},
+		{"N with variants", withOwl, variants, Stop{Code: 15201, Route: "N"}, []string{"N", "N", "N", "N_OWL"}, []int{2, 7, 14, 31}},
+		{"variants are one way", withOwl, variants, Stop{Code: 15201, Route: "N_OWL"}, []string{"N_OWL"}, []int{31}},
+		{"other route", response, variants, Stop{Code: 15201, Route: "J"}, nil, nil},
+		{"inbound", response, nil, Stop{Code: 15201, Route: "N", Direction: "Inbound"}, []string{"N", "N", "N"}, []int{2, 7, 14}},
+		{"inbound lowercase", response, nil, Stop{Code: 15201, Route: "N", Direction: "inbound"}, []string{"N", "N", "N"}, []int{2, 7, 14}},
+		{"outbound", response, nil, Stop{Code: 15201, Route: "N", Direction: "Outbound"}, nil, nil},
+		{"other stop", response, nil, Stop{Code: 15202, Route: "N"}, nil, nil},
+	}
+
+	now := time.Date(2016, 3, 1, 8, 0, 0, 0, time.UTC)
+	for _, tc := range testCases {
+		d := defaultPredictor{routeVariants: tc.variants}
+		stop := tc.stop
+		var routes []string
+		var minutes []int
+		for _, p := range d.parse(tc.response, &stop, now) {
+			routes = append(routes, p.RouteCode)
+			minutes = append(minutes, p.Minutes)
+			if p.Stop != &stop || p.Source != "511.org" || !p.CreatedAt.Equal(now) {
+				t.Errorf("%s: unexpected prediction %+v", tc.name, p)
+			}
+			if p.DirectionCode != "Inbound" {
+				t.Errorf("%s: got direction %q, expected Inbound", tc.name, p.DirectionCode)
+			}
+		}
+		if !reflect.DeepEqual(routes, tc.routes) || !reflect.DeepEqual(minutes, tc.minutes) {
+			t.Errorf("%s: got routes %v minutes %v, expected routes %v minutes %v",
+				tc.name, routes, minutes, tc.routes, tc.minutes)
+		}
+	}
+}
+
+func TestParseNextDeparturesNames(t *testing.T) {
+	response := loadNextDepartures(t)
+	withOwl := &nextDeparaturesResponse{Routes: append([]route{}, response.Routes...)}
+	owl := response.Routes[1]
+	withOwl.Routes[1].Directions = []routeDirection{owl.Directions[0]}
+	withOwl.Routes[1].Directions[0].Stops = []departureStop{owl.Directions[0].Stops[0]}
+	withOwl.Routes[1].Directions[0].Stops[0].DepartureTime = []int{31}
+
+	now := time.Date(2016, 3, 1, 8, 0, 0, 0, time.UTC)
+	d := defaultPredictor{routeVariants: routeVariants{"N": {"N_OWL"}}}
+	stop := Stop{Code: 15201, Route: "N"}
+	predictions := d.parse(withOwl, &stop, now)
+	if len(predictions) != 4 {
+		t.Fatalf("got %d predictions, expected 4", len(predictions))
+	}
+
+	// Merged departures of route variants still say which route and
+	// terminal they're for.
+	judah, night := predictions[0], predictions[3]
+	if judah.RouteCode != "N" || judah.RouteName != "N-Judah" ||
+		judah.DirectionCode != "Inbound" || judah.DirectionName != "Inbound to Caltrain via Downtown" {
+		t.Errorf("unexpected N prediction %+v", judah)
+	}
+	if night.RouteCode != "N_OWL" || night.RouteName != "N-Owl" ||
+		night.DirectionCode != "Inbound" || night.DirectionName != "Inbound to Ocean Beach" {
+		t.Errorf("unexpected N_OWL prediction %+v", night)
+	}
+
+	b, err := json.Marshal(judah)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"route_code":"N"`, `"route_name":"N-Judah"`, `"direction_code":"Inbound"`, `"direction_name":"Inbound to Caltrain via Downtown"`} {
+		if !strings.Contains(string(b), field) {
+			t.Errorf("expected %s in %s", field, b)
+		}
+	}
+}
+
+func TestDefaultPredictorCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+	d := defaultPredictor{
+		serviceURL:  server.URL + "/GetNextDeparturesByStopCode.aspx",
+		client:      newUpstreamClient(upstreamConfig{}),
+		accessToken: "secret",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := d.Predict(ctx, &Stop{Route: "N", Code: 15201}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, expected the request to be cancelled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Duration(defaultUpstreamTimeout)/2 {
+		t.Errorf("took %s to give up, expected the request to be cancelled right away", elapsed)
+	}
+}
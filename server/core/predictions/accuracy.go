@@ -0,0 +1,343 @@
+package predictions
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// departedMinutes is the countdown at or below which a departure that
+	// disappears from the next refresh is taken to have left.
+	departedMinutes = 1
+	// minObservationGap is the shortest gap between refreshes over which a
+	// departure's actual time is still estimated, however often the stop is
+	// refreshed.
+	minObservationGap = 2 * time.Minute
+	// observationGapFactor is how many refresh intervals a gap between
+	// refreshes may span before departures that leave during it aren't
+	// counted, ex. while a source is down.
+	observationGapFactor = 2
+	// leadTolerance is how close to the lead time a prediction must have
+	// been made to be compared.
+	leadTolerance = time.Minute
+	// maxAccuracySamples bounds how many errors are kept per distribution.
+	maxAccuracySamples = 1000
+)
+
+var (
+	// accuracyLeads are how far ahead of departures their predictions are
+	// compared against when they actually left.
+	accuracyLeads = []time.Duration{5 * time.Minute, 10 * time.Minute, 15 * time.Minute}
+)
+
+// ErrorDistribution summarizes how far actual departures were from their
+// predictions. Errors are positive when departures left later than predicted.
+type ErrorDistribution struct {
+	Count               int     `json:"count"`
+	MeanSeconds         float64 `json:"mean_seconds"`
+	MeanAbsoluteSeconds float64 `json:"mean_absolute_seconds"`
+	P10Seconds          float64 `json:"p10_seconds"`
+	MedianSeconds       float64 `json:"median_seconds"`
+	P90Seconds          float64 `json:"p90_seconds"`
+	// Histogram counts errors by whole minutes, rounded down, ex. -1 counts
+	// departures that left up to a minute early.
+	Histogram map[int]int `json:"histogram"`
+}
+
+// StopAccuracy reports how accurate a stop's predictions have been.
+type StopAccuracy struct {
+	// Departures is the number of departures seen leaving the stop.
+	Departures int `json:"departures"`
+	// ByLead maps how far ahead departures were predicted, ex. "5m0s", to
+	// the errors of those predictions.
+	ByLead map[string]ErrorDistribution `json:"by_lead"`
+	// ByHour breaks each lead down by the hour of the day that the
+	// departures left.
+	ByHour map[string]map[int]ErrorDistribution `json:"by_hour"`
+}
+
+// observation is what a departure was predicted to do as of a refresh.
+type observation struct {
+	at        time.Time
+	departsAt time.Time
+}
+
+// trackedDeparture is a departure followed across refreshes.
+type trackedDeparture struct {
+	tripID       string
+	vehicleID    string
+	route        string
+	lastMinutes  int
+	observations []observation
+}
+
+func (d *trackedDeparture) last() observation {
+	return d.observations[len(d.observations)-1]
+}
+
+// departure is a departure that's been seen leaving.
+type departure struct {
+	*trackedDeparture
+	actual time.Time
+}
+
+// departureTracker infers when departures actually leave from successive
+// refreshes of each stop. It isn't safe for concurrent use.
+type departureTracker struct {
+	departures map[string][]*trackedDeparture
+	// intervals maps stops to how often they were refreshed as of their
+	// last refresh.
+	intervals map[string]time.Duration
+}
+
+func newDepartureTracker() *departureTracker {
+	return &departureTracker{
+		departures: make(map[string][]*trackedDeparture),
+		intervals:  make(map[string]time.Duration),
+	}
+}
+
+// observe updates the stop's departures with the predictions from a refresh,
+// and returns the departures that have left since the last refresh: those
+// that were about to leave and are no longer predicted. The interval is how
+// often the stop is refreshed, or 0 if it isn't known.
+func (t *departureTracker) observe(stop string, now time.Time, interval time.Duration, predictions []Prediction) []departure {
+	// A stop that just became idle, or was just requested, was refreshed at
+	// the slower of its old and new intervals since the last refresh.
+	maxGap := observationGap(interval)
+	if gap := observationGap(t.intervals[stop]); gap > maxGap {
+		maxGap = gap
+	}
+	t.intervals[stop] = interval
+
+	previous := t.departures[stop]
+	matched := make(map[*trackedDeparture]bool)
+	var current []*trackedDeparture
+	for _, p := range predictions {
+		d := match(previous, matched, p)
+		if d == nil {
+			d = &trackedDeparture{tripID: p.TripID, vehicleID: p.VehicleID, route: p.RouteCode}
+		}
+		matched[d] = true
+		d.lastMinutes = p.Minutes
+		d.observations = append(d.observations, observation{at: now, departsAt: p.DepartsAt})
+		current = append(current, d)
+	}
+	t.departures[stop] = current
+
+	var departed []departure
+	for _, d := range previous {
+		if matched[d] || d.lastMinutes > departedMinutes {
+			continue
+		}
+		lastSeen := d.last().at
+		if now.Sub(lastSeen) > maxGap {
+			continue
+		}
+		// It left at some point between the last refresh that predicted
+		// it and this one.
+		departed = append(departed, departure{d, lastSeen.Add(now.Sub(lastSeen) / 2)})
+	}
+	return departed
+}
+
+// observationGap returns the longest time between refreshes over which a
+// departure's actual time is still estimated, for a stop refreshed at the
+// given interval.
+func observationGap(interval time.Duration) time.Duration {
+	if gap := observationGapFactor * interval; gap > minObservationGap {
+		return gap
+	}
+	return minObservationGap
+}
+
+type refreshIntervalKey struct{}
+
+// withRefreshInterval returns a context that tells predictors how often the
+// stop is being refreshed.
+func withRefreshInterval(ctx context.Context, interval time.Duration) context.Context {
+	return context.WithValue(ctx, refreshIntervalKey{}, interval)
+}
+
+// refreshInterval returns how often the stop is being refreshed according to
+// the context, or 0 if it isn't known.
+func refreshInterval(ctx context.Context) time.Duration {
+	interval, _ := ctx.Value(refreshIntervalKey{}).(time.Duration)
+	return interval
+}
+
+type idleRefreshKey struct{}
+
+// withIdleRefresh returns a context that tells predictors the refresh is of an
+// idle stop, which they shouldn't learn from.
+func withIdleRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idleRefreshKey{}, true)
+}
+
+// idleRefresh returns true if the context is of a refresh of an idle stop.
+func idleRefresh(ctx context.Context) bool {
+	idle, _ := ctx.Value(idleRefreshKey{}).(bool)
+	return idle
+}
+
+// match returns the previously seen departure that the prediction belongs
+// to, matching by trip or vehicle if possible and otherwise by the closest
+// departure time on the same route.
+func match(departures []*trackedDeparture, matched map[*trackedDeparture]bool, p Prediction) *trackedDeparture {
+	var closest *trackedDeparture
+	var closestDiff time.Duration
+	for _, d := range departures {
+		if matched[d] {
+			continue
+		}
+		if p.TripID != "" && d.tripID == p.TripID {
+			return d
+		}
+		if p.VehicleID != "" && d.vehicleID == p.VehicleID {
+			return d
+		}
+		if !strings.EqualFold(p.RouteCode, d.route) {
+			continue
+		}
+		diff := p.DepartsAt.Sub(d.last().departsAt)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= defaultMatchTolerance && (closest == nil || diff < closestDiff) {
+			closest, closestDiff = d, diff
+		}
+	}
+	return closest
+}
+
+// accuracyTracker compares when departures actually leave with what was
+// predicted beforehand.
+type accuracyTracker struct {
+	mu       sync.Mutex
+	tracker  *departureTracker
+	departed map[string]int
+	// errors maps stops to leads to hours of the day to errors in seconds.
+	errors map[string]map[time.Duration]map[int][]float64
+}
+
+func newAccuracyTracker() *accuracyTracker {
+	return &accuracyTracker{
+		tracker:  newDepartureTracker(),
+		departed: make(map[string]int),
+		errors:   make(map[string]map[time.Duration]map[int][]float64),
+	}
+}
+
+// observe records the accuracy of the departures that have left since the
+// stop's last refresh, and returns them. The interval is how often the stop
+// is refreshed.
+func (a *accuracyTracker) observe(stop string, now time.Time, interval time.Duration, predictions []Prediction) []departure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	departed := a.tracker.observe(stop, now, interval, predictions)
+	for _, d := range departed {
+		a.record(stop, d.trackedDeparture, d.actual)
+	}
+	return departed
+}
+
+// record compares the departure's actual time with what was predicted at
+// each lead time beforehand.
+func (a *accuracyTracker) record(stop string, d *trackedDeparture, actual time.Time) {
+	a.departed[stop]++
+	if a.errors[stop] == nil {
+		a.errors[stop] = make(map[time.Duration]map[int][]float64)
+	}
+	for _, lead := range accuracyLeads {
+		o, ok := d.observationAt(actual.Add(-lead))
+		if !ok {
+			continue
+		}
+		if a.errors[stop][lead] == nil {
+			a.errors[stop][lead] = make(map[int][]float64)
+		}
+		hour := actual.Hour()
+		errs := append(a.errors[stop][lead][hour], actual.Sub(o.departsAt).Seconds())
+		if len(errs) > maxAccuracySamples {
+			errs = errs[len(errs)-maxAccuracySamples:]
+		}
+		a.errors[stop][lead][hour] = errs
+	}
+}
+
+// observationAt returns the latest observation made at or before t, if it
+// was made close enough to t.
+func (d *trackedDeparture) observationAt(t time.Time) (observation, bool) {
+	for i := len(d.observations) - 1; i >= 0; i-- {
+		o := d.observations[i]
+		if o.at.After(t) {
+			continue
+		}
+		return o, t.Sub(o.at) <= leadTolerance
+	}
+	return observation{}, false
+}
+
+// report summarizes the accuracy of the stop's predictions.
+func (a *accuracyTracker) report(stop string) StopAccuracy {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	res := StopAccuracy{
+		Departures: a.departed[stop],
+		ByLead:     make(map[string]ErrorDistribution),
+		ByHour:     make(map[string]map[int]ErrorDistribution),
+	}
+	for lead, hours := range a.errors[stop] {
+		var all []float64
+		byHour := make(map[int]ErrorDistribution)
+		for hour, errs := range hours {
+			all = append(all, errs...)
+			byHour[hour] = distribution(errs)
+		}
+		res.ByLead[lead.String()] = distribution(all)
+		res.ByHour[lead.String()] = byHour
+	}
+	return res
+}
+
+// distribution summarizes errors, in seconds.
+func distribution(errs []float64) ErrorDistribution {
+	d := ErrorDistribution{Count: len(errs), Histogram: make(map[int]int)}
+	if len(errs) == 0 {
+		return d
+	}
+	sorted := append([]float64(nil), errs...)
+	sort.Float64s(sorted)
+	var sum, absSum float64
+	for _, e := range sorted {
+		sum += e
+		absSum += math.Abs(e)
+		d.Histogram[int(math.Floor(e/60))]++
+	}
+	d.MeanSeconds = sum / float64(len(sorted))
+	d.MeanAbsoluteSeconds = absSum / float64(len(sorted))
+	d.P10Seconds = percentile(sorted, 0.1)
+	d.MedianSeconds = percentile(sorted, 0.5)
+	d.P90Seconds = percentile(sorted, 0.9)
+	return d
+}
+
+// percentile returns the pth percentile of sorted values, interpolating
+// between the nearest two.
+func percentile(sorted []float64, p float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+// Accuracy reports how accurate the stop's predictions have been, judged
+// against when departures were seen leaving.
+func (m *Module) Accuracy(stop string) StopAccuracy {
+	return m.accuracy.report(stop)
+}
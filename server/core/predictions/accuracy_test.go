@@ -0,0 +1,93 @@
+package predictions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDepartureTrackerObservationGap(t *testing.T) {
+	start := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	leaving := func(at time.Time, tripID string) []Prediction {
+		return []Prediction{{TripID: tripID, DepartsAt: at.Add(30 * time.Second), Minutes: 0}}
+	}
+
+	testCases := []struct {
+		name string
+		// intervals are how often the stop was refreshed as of the first
+		// and second refreshes.
+		intervals [2]time.Duration
+		gap       time.Duration
+		departed  bool
+	}{
+		{"scheduled", [2]time.Duration{20 * time.Second, 20 * time.Second}, 20 * time.Second, true},
+		{"slowed by quota", [2]time.Duration{time.Minute, 20 * time.Second}, time.Minute, true},
+		{"unknown interval", [2]time.Duration{0, 0}, 90 * time.Second, true},
+		// The source was down for a while.
+		{"outage", [2]time.Duration{20 * time.Second, 20 * time.Second}, 30 * time.Minute, false},
+	}
+	for _, tc := range testCases {
+		tracker := newDepartureTracker()
+		tracker.observe("judah", start, tc.intervals[0], leaving(start, "11239834"))
+		departed := tracker.observe("judah", start.Add(tc.gap), tc.intervals[1], nil)
+		if (len(departed) == 1) != tc.departed {
+			t.Errorf("%s: got %d departures, expected departed to be %t", tc.name, len(departed), tc.departed)
+		}
+	}
+}
+
+func TestUpdateInterval(t *testing.T) {
+	m := &Module{holidays: make(map[string]bool)}
+	schedule := composite(atNight(interval(time.Minute)), onWeekends(interval(30*time.Second)), interval(20*time.Second))
+
+	testCases := []struct {
+		now      time.Time
+		interval time.Duration
+	}{
+		// Thursday afternoon.
+		{time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC), 20 * time.Second},
+		{time.Date(2016, 6, 30, 23, 30, 0, 0, time.UTC), time.Minute},
+		// Saturday.
+		{time.Date(2016, 7, 2, 12, 0, 0, 0, time.UTC), 30 * time.Second},
+	}
+	for _, tc := range testCases {
+		if interval := schedule(tc.now, m); interval == nil || *interval != tc.interval {
+			t.Errorf("%s: got %v, expected %s", tc.now, interval, tc.interval)
+		}
+	}
+
+	never := inHours(7, 10, interval(time.Minute))
+	if interval := never(time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC), m); interval != nil {
+		t.Errorf("got %s outside the schedule's hours, expected none", *interval)
+	}
+}
+
+func TestIdleRefreshesAreNotObserved(t *testing.T) {
+	leaving := &stubPredictor{predictions: []Prediction{{TripID: "11239834", Minutes: 0, RouteCode: "N"}}}
+	corrected := newBiasCorrector(leaving, 0, 0, nil)
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "corrected"},
+	}, map[string]Predictor{"corrected": corrected})
+	m.lastRequested["judah"] = time.Time{}
+
+	if err := m.refreshPredictionsForStop("judah"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Current("judah")) != 1 {
+		t.Fatalf("expected the idle stop's predictions to be kept")
+	}
+	if n := len(m.accuracy.tracker.departures); n != 0 {
+		t.Errorf("expected accuracy to ignore the idle refresh, tracking %d stops", n)
+	}
+	if n := len(corrected.tracker.departures); n != 0 {
+		t.Errorf("expected the corrector to ignore the idle refresh, tracking %d stops", n)
+	}
+
+	// Once the stop is requested, its refreshes are observed again.
+	m.lastRequested["judah"] = time.Now()
+	if err := m.refreshPredictionsForStop("judah"); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.accuracy.tracker.departures) != 1 || len(corrected.tracker.departures) != 1 {
+		t.Errorf("expected the requested stop's refresh to be observed")
+	}
+}
@@ -0,0 +1,273 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinSamples  = 20
+	defaultBucketHours = 1
+	defaultLearnWindow = 14 * 24 * time.Hour
+	// leadBucket is the width of the buckets that errors are grouped into by
+	// how far ahead the departure was predicted.
+	leadBucket = 5 * time.Minute
+	// biasMemory bounds how many samples the learned error averages over, so
+	// that it follows changes in the upstream's behavior.
+	biasMemory = 500
+)
+
+// correctedConfig configures a "corrected" source, which adjusts the
+// predictions of Source by the errors it's learned. A correction is only
+// applied once MinSamples departures have been seen for its stop, route,
+// BucketHours-long time of day and lead time. When the module starts, it
+// learns from the last Window of history, if the history is enabled.
+type correctedConfig struct {
+	Source      string   `json:"source"`
+	MinSamples  int      `json:"min_samples"`
+	BucketHours int      `json:"bucket_hours"`
+	Window      duration `json:"window"`
+}
+
+// biasKey identifies the predictions that share a learned error.
+type biasKey struct {
+	stop  string
+	route string
+	// hour is the first hour of the time of day bucket.
+	hour int
+	// lead is the start of the lead time bucket.
+	lead time.Duration
+}
+
+// biasStats is the average error of predictions, in seconds, with one sample
+// per departure. Errors are positive when departures left later than
+// predicted.
+type biasStats struct {
+	count int
+	mean  float64
+}
+
+func (s *biasStats) add(err float64) {
+	s.count++
+	n := s.count
+	if n > biasMemory {
+		n = biasMemory
+	}
+	s.mean += (err - s.mean) / float64(n)
+}
+
+// biasCorrector is a Predictor decorator that learns each stop's prediction
+// error from the departures it sees leave, and corrects its source's
+// predictions by it.
+type biasCorrector struct {
+	predictor   Predictor
+	minSamples  int
+	bucketHours int
+	location    func() *time.Location
+	// history and window are where and how far back learn looks, if the
+	// history is enabled.
+	history *historyStore
+	window  time.Duration
+
+	mu      sync.Mutex
+	tracker *departureTracker
+	stats   map[biasKey]*biasStats
+}
+
+var _ Predictor = &biasCorrector{}
+
+// historyLearner is implemented by sources that learn from the history of the
+// stops that use them. The module calls learn in the background when it
+// starts, once the timezone is known.
+type historyLearner interface {
+	learn(ctx context.Context, stops map[string]Stop) error
+}
+
+var _ historyLearner = &biasCorrector{}
+
+// newCorrectedSource builds a biasCorrector around the source in its options.
+func newCorrectedSource(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	var o correctedConfig
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	if o.Source == "" {
+		return nil, fmt.Errorf("Source %q has no source to correct", c.name)
+	}
+	predictor, err := c.source(o.Source)
+	if err != nil {
+		return nil, err
+	}
+	b := newBiasCorrector(predictor, o.MinSamples, o.BucketHours, c.location)
+	b.history = c.history
+	b.window = time.Duration(o.Window)
+	if b.window <= 0 {
+		b.window = defaultLearnWindow
+	}
+	return b, nil
+}
+
+// newBiasCorrector returns a corrector around the predictor. location
+// returns the timezone that times of day are in.
+func newBiasCorrector(predictor Predictor, minSamples, bucketHours int, location func() *time.Location) *biasCorrector {
+	if minSamples <= 0 {
+		minSamples = defaultMinSamples
+	}
+	if bucketHours <= 0 || bucketHours > 24 {
+		bucketHours = defaultBucketHours
+	}
+	return &biasCorrector{
+		predictor:   predictor,
+		minSamples:  minSamples,
+		bucketHours: bucketHours,
+		location:    location,
+		tracker:     newDepartureTracker(),
+		stats:       make(map[biasKey]*biasStats),
+	}
+}
+
+func (b *biasCorrector) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	predictions, err := b.predictor.Predict(ctx, stop)
+	if err != nil {
+		return nil, err
+	}
+	now := clock(ctx)
+	fillDepartureTimes(predictions)
+	if !idleRefresh(ctx) {
+		b.observe(b.tracker, stop, now, refreshInterval(ctx), predictions)
+	}
+	return b.correct(stop, now, predictions), nil
+}
+
+// learn learns from the last window of the stops' history.
+func (b *biasCorrector) learn(ctx context.Context, stops map[string]Stop) error {
+	if b.history == nil {
+		return nil
+	}
+	now := time.Now()
+	for key, stop := range stops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := b.history.query(key, now.Add(-b.window), now)
+		if err != nil {
+			return fmt.Errorf("Unable to read history of %s: %s", key, err.Error())
+		}
+		b.learnHistory(&stop, entries)
+	}
+	return nil
+}
+
+// learnHistory learns from the stop's past predictions, oldest first. It
+// learns from the raw values of predictions that were corrected. Departures
+// are followed apart from live refreshes, which may run meanwhile. The
+// history doesn't say how often the stop was meant to be refreshed, so it's
+// taken to be the gap between the two refreshes before each one. Refreshes from
+// while the stop was idle are left out, like live ones.
+func (b *biasCorrector) learnHistory(stop *Stop, entries []HistoryEntry) {
+	var active []HistoryEntry
+	for _, entry := range entries {
+		if !entry.Idle {
+			active = append(active, entry)
+		}
+	}
+	entries = active
+
+	tracker := newDepartureTracker()
+	for i, entry := range entries {
+		var interval time.Duration
+		if i > 1 {
+			interval = entries[i-1].RefreshedAt.Sub(entries[i-2].RefreshedAt)
+		}
+		predictions := make([]Prediction, len(entry.Predictions))
+		for i, p := range entry.Predictions {
+			if p.Corrected && p.RawDepartsAt != nil && p.RawMinutes != nil {
+				p.DepartsAt, p.Minutes = *p.RawDepartsAt, *p.RawMinutes
+			}
+			predictions[i] = p
+		}
+		b.observe(tracker, stop, entry.RefreshedAt, interval, predictions)
+	}
+}
+
+// observe learns the errors of the departures that the tracker sees leave
+// since the stop's last refresh. A departure is predicted by many refreshes,
+// so its errors are averaged within each bucket, and it's counted once per
+// bucket.
+func (b *biasCorrector) observe(tracker *departureTracker, stop *Stop, now time.Time, interval time.Duration, predictions []Prediction) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range tracker.observe(stopID(stop), now, interval, predictions) {
+		var keys []biasKey
+		sums := make(map[biasKey]float64)
+		counts := make(map[biasKey]int)
+		for _, o := range d.observations {
+			key := b.key(stop, d.route, o.at, o.departsAt.Sub(o.at))
+			if counts[key] == 0 {
+				keys = append(keys, key)
+			}
+			sums[key] += d.actual.Sub(o.departsAt).Seconds()
+			counts[key]++
+		}
+		for _, key := range keys {
+			stats, ok := b.stats[key]
+			if !ok {
+				stats = &biasStats{}
+				b.stats[key] = stats
+			}
+			stats.add(sums[key] / float64(counts[key]))
+		}
+	}
+}
+
+// correct returns copies of the predictions adjusted by their learned errors,
+// keeping the raw values alongside. Predictions without enough samples are
+// left as they are.
+func (b *biasCorrector) correct(stop *Stop, now time.Time, predictions []Prediction) []Prediction {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	corrected := make([]Prediction, len(predictions))
+	for i, p := range predictions {
+		key := b.key(stop, p.RouteCode, now, p.DepartsAt.Sub(now))
+		if stats, ok := b.stats[key]; ok && stats.count >= b.minSamples {
+			rawDepartsAt, rawMinutes := p.DepartsAt, p.Minutes
+			p.Corrected = true
+			p.RawDepartsAt = &rawDepartsAt
+			p.RawMinutes = &rawMinutes
+			p.DepartsAt = p.DepartsAt.Add(time.Duration(stats.mean * float64(time.Second)))
+			p.Minutes = int(p.DepartsAt.Sub(now) / time.Minute)
+			if p.Minutes < 0 {
+				p.Minutes = 0
+			}
+		}
+		corrected[i] = p
+	}
+	return corrected
+}
+
+// key returns the bucket of a prediction made at the given time with the
+// given lead time.
+func (b *biasCorrector) key(stop *Stop, route string, at time.Time, lead time.Duration) biasKey {
+	if b.location != nil && b.location() != nil {
+		at = at.In(b.location())
+	}
+	if lead < 0 {
+		lead = 0
+	}
+	return biasKey{
+		stop:  stopID(stop),
+		route: strings.ToUpper(route),
+		hour:  at.Hour() / b.bucketHours * b.bucketHours,
+		lead:  lead / leadBucket * leadBucket,
+	}
+}
+
+// stopID identifies a stop regardless of its key in stops.json.
+func stopID(stop *Stop) string {
+	return fmt.Sprintf("%s/%d/%s/%s", stop.Agency, stop.Code, stop.Route, stop.Direction)
+}
@@ -0,0 +1,200 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lateHistory returns the refreshes, every 20 seconds, of a source that
+// predicts every departure two minutes early. Departures leave every 10
+// minutes and are predicted from 20 minutes ahead until they leave.
+func lateHistory(start time.Time, departures int) []HistoryEntry {
+	var actual []time.Time
+	for i := 0; i < departures; i++ {
+		actual = append(actual, start.Add(15*time.Minute+time.Duration(i)*10*time.Minute))
+	}
+	end := actual[len(actual)-1].Add(time.Minute)
+
+	var entries []HistoryEntry
+	for t := start; !t.After(end); t = t.Add(20 * time.Second) {
+		var predictions []Prediction
+		for i, a := range actual {
+			departsAt := a.Add(-2 * time.Minute)
+			if !t.Before(a) || departsAt.Sub(t) > 20*time.Minute {
+				continue
+			}
+			minutes := int(departsAt.Sub(t) / time.Minute)
+			if minutes < 0 {
+				minutes = 0
+			}
+			predictions = append(predictions, Prediction{
+				CreatedAt: t,
+				DepartsAt: departsAt,
+				Minutes:   minutes,
+				TripID:    fmt.Sprintf("trip-%d", i),
+				RouteCode: "N",
+			})
+		}
+		entries = append(entries, HistoryEntry{RefreshedAt: t, Predictions: predictions})
+	}
+	return entries
+}
+
+func utc() *time.Location { return time.UTC }
+
+func TestBiasCorrectorLearnsOncePerDeparture(t *testing.T) {
+	stop := Stop{Agency: "SF-MUNI", Route: "N", Direction: "Inbound", Code: 15201}
+	start := time.Date(2016, 6, 30, 7, 0, 0, 0, time.UTC)
+	b := newBiasCorrector(nil, 5, 24, utc)
+	b.learnHistory(&stop, lateHistory(start, 6))
+
+	// Each departure is observed 15 times with a lead of 5 to 10 minutes,
+	// but counts once. The departures are seen leaving 10 seconds before
+	// the refresh that no longer has them, so they're 110 seconds late.
+	stats, ok := b.stats[b.key(&stop, "N", start, 7*time.Minute)]
+	if !ok {
+		t.Fatal("expected errors for a 5 to 10 minute lead")
+	}
+	if stats.count != 6 || math.Abs(stats.mean-110) > 0.001 {
+		t.Errorf("got %d samples with a mean of %.1fs, expected 6 with a mean of 110s", stats.count, stats.mean)
+	}
+	if len(b.tracker.departures) != 0 {
+		t.Errorf("expected the history to be followed apart from live refreshes, got %d stops", len(b.tracker.departures))
+	}
+
+	now := start.Add(3 * time.Hour)
+	raw := []Prediction{
+		{CreatedAt: now, DepartsAt: now.Add(7 * time.Minute), Minutes: 7, RouteCode: "N"},
+		{CreatedAt: now, DepartsAt: now.Add(30 * time.Second), Minutes: 0, RouteCode: "N"},
+		{CreatedAt: now, DepartsAt: now.Add(7 * time.Minute), Minutes: 7, RouteCode: "NX"},
+	}
+	corrected := b.correct(&stop, now, raw)
+
+	p := corrected[0]
+	if !p.Corrected || !p.DepartsAt.Equal(now.Add(7*time.Minute+110*time.Second)) || p.Minutes != 8 {
+		t.Errorf("got %s (%d minutes), expected a correction of 110s", p.DepartsAt, p.Minutes)
+	}
+	if p.RawDepartsAt == nil || !p.RawDepartsAt.Equal(raw[0].DepartsAt) || p.RawMinutes == nil || *p.RawMinutes != 7 {
+		t.Errorf("unexpected raw values in %+v", p)
+	}
+	if raw[0].Corrected {
+		t.Error("expected the source's predictions to be left as they were")
+	}
+
+	// A raw value of 0 minutes is kept.
+	b2, err := json.Marshal(corrected[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !corrected[1].Corrected || !strings.Contains(string(b2), `"raw_minutes":0`) {
+		t.Errorf("expected raw_minutes to be 0 in %s", b2)
+	}
+
+	// There aren't any samples for the NX.
+	b3, err := json.Marshal(corrected[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corrected[2].Corrected || strings.Contains(string(b3), "raw_") {
+		t.Errorf("expected an uncorrected prediction, got %s", b3)
+	}
+}
+
+func TestBiasCorrectorMinSamples(t *testing.T) {
+	stop := Stop{Route: "N", Code: 15201}
+	start := time.Date(2016, 6, 30, 7, 0, 0, 0, time.UTC)
+	b := newBiasCorrector(nil, 5, 24, utc)
+	b.learnHistory(&stop, lateHistory(start, 4))
+
+	now := start.Add(3 * time.Hour)
+	p := b.correct(&stop, now, []Prediction{{CreatedAt: now, DepartsAt: now.Add(7 * time.Minute), Minutes: 7, RouteCode: "N"}})[0]
+	if p.Corrected || p.Minutes != 7 {
+		t.Errorf("expected no correction from 4 departures, got %+v", p)
+	}
+}
+
+func TestLearnHistorySkipsIdleRefreshes(t *testing.T) {
+	stop := Stop{Route: "N", Code: 15201}
+	start := time.Date(2016, 6, 30, 7, 0, 0, 0, time.UTC)
+	entries := lateHistory(start, 6)
+	for i := range entries {
+		entries[i].Idle = true
+	}
+	b := newBiasCorrector(nil, 5, 24, utc)
+	b.learnHistory(&stop, entries)
+	if len(b.stats) != 0 {
+		t.Errorf("expected nothing to be learned from idle refreshes, got %d buckets", len(b.stats))
+	}
+}
+
+func TestLearnFromHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	history, err := newHistoryStore(historyConfig{Path: filepath.Join(dir, "history.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.close()
+
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Minute)
+	for _, key := range []string{"judah", "church"} {
+		for _, entry := range lateHistory(start, 6) {
+			if err := history.append(key, entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	b := newBiasCorrectorWithHistory(history)
+	judah := Stop{Route: "N", Code: 15201, Source: "fallback"}
+	church := Stop{Route: "N", Code: 15731, Source: "511.org"}
+	m := &Module{
+		ctx:   context.Background(),
+		stops: map[string]Stop{"judah": judah, "church": church},
+		predictors: map[string]Predictor{
+			"511.org":   &defaultPredictor{},
+			"corrected": b,
+			"fallback":  &fallbackPredictor{},
+		},
+		sourceDependencies: map[string][]string{
+			"corrected": {"511.org"},
+			"fallback":  {"corrected", "schedule"},
+		},
+	}
+	m.learnFromHistory()
+
+	// Only the stop that uses the corrector, through the fallback, is
+	// learned from.
+	if stats, ok := b.stats[b.key(&judah, "N", start, 7*time.Minute)]; !ok || stats.count != 6 {
+		t.Errorf("expected 6 samples for the stop using the corrector, got %+v", stats)
+	}
+	for key := range b.stats {
+		if key.stop == stopID(&church) {
+			t.Fatalf("learned from a stop that doesn't use the corrector: %+v", key)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newBiasCorrectorWithHistory(history).learn(ctx, m.stops); err != context.Canceled {
+		t.Errorf("got %v from a cancelled context, expected context.Canceled", err)
+	}
+}
+
+func newBiasCorrectorWithHistory(history *historyStore) *biasCorrector {
+	b := newBiasCorrector(nil, 5, 24, utc)
+	b.history = history
+	b.window = 24 * time.Hour
+	return b
+}
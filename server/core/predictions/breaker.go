@@ -0,0 +1,125 @@
+package predictions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+
+	defaultBreakerThreshold = 5
+	defaultBackoff          = 5 * time.Second
+	defaultMaxBackoff       = 5 * time.Minute
+)
+
+// breakerConfig configures how refreshes of a failing stop are backed off.
+// After Threshold consecutive failures the stop's circuit breaker opens, and
+// only a single probe is let through once the backoff expires.
+type breakerConfig struct {
+	Threshold  int      `json:"failure_threshold"`
+	Backoff    duration `json:"backoff"`
+	MaxBackoff duration `json:"max_backoff"`
+}
+
+// breaker is a circuit breaker around refreshes of a single stop. Failed
+// refreshes are retried with exponential backoff and jitter.
+type breaker struct {
+	key        string
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	state    string
+	failures int
+	retryAt  time.Time
+}
+
+func newBreaker(key string, c breakerConfig) *breaker {
+	b := &breaker{
+		key:        key,
+		threshold:  c.Threshold,
+		backoff:    time.Duration(c.Backoff),
+		maxBackoff: time.Duration(c.MaxBackoff),
+		state:      breakerClosed,
+	}
+	if b.threshold <= 0 {
+		b.threshold = defaultBreakerThreshold
+	}
+	if b.backoff <= 0 {
+		b.backoff = defaultBackoff
+	}
+	if b.maxBackoff <= 0 {
+		b.maxBackoff = defaultMaxBackoff
+	}
+	return b
+}
+
+// allow returns true if the stop may be refreshed now. Once an open breaker's
+// backoff expires, it lets a single probe through.
+func (b *breaker) allow(now time.Time) bool {
+	switch b.state {
+	case breakerHalfOpen:
+		return false
+	case breakerOpen:
+		if now.Before(b.retryAt) {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	default:
+		return !now.Before(b.retryAt)
+	}
+}
+
+// record updates the breaker with the outcome of a refresh. Refreshes that
+// were cancelled, ex. because the server is stopping, say nothing about the
+// source, so they don't count as failures. A cancelled probe lets another
+// one through.
+func (b *breaker) record(err error, now time.Time) {
+	if errors.Is(err, context.Canceled) {
+		if b.state == breakerHalfOpen {
+			b.setState(breakerOpen)
+		}
+		return
+	}
+	if err == nil {
+		b.failures = 0
+		b.retryAt = time.Time{}
+		b.setState(breakerClosed)
+		return
+	}
+
+	b.failures++
+	b.retryAt = now.Add(b.delay())
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.setState(breakerOpen)
+	}
+}
+
+// delay returns how long to wait before retrying after the current number of
+// consecutive failures. It doubles with every failure, and a random half of
+// it is jittered so that stops sharing a source don't retry in lockstep.
+func (b *breaker) delay() time.Duration {
+	d := b.backoff
+	for i := 1; i < b.failures && d < b.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > b.maxBackoff {
+		d = b.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (b *breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	fmt.Printf("%s: circuit breaker %s -> %s\n", b.key, b.state, state)
+	b.state = state
+}
@@ -0,0 +1,107 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreakerIgnoresCancellation(t *testing.T) {
+	now := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	b := newBreaker("judah", breakerConfig{Threshold: 2})
+	failure := errors.New("The 511.org API responded with a non-200 status code: 500")
+	cancelled := fmt.Errorf("Get http://services.my511.org: %w", context.Canceled)
+
+	b.record(failure, now)
+	for i := 0; i < 5; i++ {
+		b.record(cancelled, now)
+	}
+	if b.state != breakerClosed || b.failures != 1 {
+		t.Fatalf("got state %s with %d failures, expected closed with 1", b.state, b.failures)
+	}
+
+	b.record(failure, now)
+	if b.state != breakerOpen {
+		t.Fatalf("got state %s, expected open", b.state)
+	}
+	now = b.retryAt
+	if !b.allow(now) || b.state != breakerHalfOpen {
+		t.Fatalf("expected a probe once the backoff expires, got state %s", b.state)
+	}
+
+	// The probe is cancelled, so another one is let through.
+	b.record(context.Canceled, now)
+	if b.state != breakerOpen || b.failures != 2 || !b.allow(now) {
+		t.Errorf("got state %s with %d failures, expected another probe", b.state, b.failures)
+	}
+	b.record(nil, now)
+	if b.state != breakerClosed || b.failures != 0 || !b.retryAt.IsZero() {
+		t.Errorf("got state %s with %d failures, expected closed", b.state, b.failures)
+	}
+}
+
+func TestStopStatusBreaker(t *testing.T) {
+	source := &stubPredictor{err: errors.New("The 511.org API responded with a non-200 status code: 500")}
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "511.org"},
+	}, map[string]Predictor{"511.org": source})
+	m.breakers["judah"] = newBreaker("judah", breakerConfig{Threshold: 2})
+	refresh := func(now time.Time) bool {
+		due := m.dueStops(now)
+		for _, key := range due {
+			m.refreshStop(key)
+		}
+		return len(due) == 1
+	}
+
+	for i := 0; i < 2; i++ {
+		now := time.Now()
+		if status := m.Status("judah"); status.RetryAt != nil {
+			now = *status.RetryAt
+		}
+		if !refresh(now) {
+			t.Fatalf("expected failure %d to be refreshed", i+1)
+		}
+	}
+	status := m.Status("judah")
+	if status.Breaker != breakerOpen || status.RetryAt == nil || !status.RetryAt.After(status.LastAttempt) {
+		t.Fatalf("expected an open breaker with a retry time, got %+v", status)
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"retry_at"`) {
+		t.Errorf("expected retry_at, got %s", b)
+	}
+	if refresh(status.RetryAt.Add(-time.Millisecond)) {
+		t.Error("expected no refresh before the retry time")
+	}
+
+	// Once the backoff expires, a single probe is let through.
+	retryAt := *status.RetryAt
+	due := m.dueStops(retryAt)
+	if len(due) != 1 {
+		t.Fatal("expected a probe at the retry time")
+	}
+	if status := m.Status("judah"); status.Breaker != breakerHalfOpen || status.RetryAt == nil || !status.RetryAt.Equal(retryAt) {
+		t.Errorf("expected a half-open breaker while probing, got %+v", status)
+	}
+	source.err = nil
+	m.refreshStop("judah")
+
+	status = m.Status("judah")
+	if status.Breaker != breakerClosed || status.RetryAt != nil || status.ConsecutiveFailures != 0 {
+		t.Errorf("expected a closed breaker after the probe succeeded, got %+v", status)
+	}
+	if b, err = json.Marshal(status); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "retry_at") {
+		t.Errorf("expected no retry_at for a closed breaker, got %s", b)
+	}
+}
@@ -0,0 +1,79 @@
+package predictions
+
+import (
+	"fmt"
+	"time"
+	// Embed the timezone database, so that the default timezone loads on
+	// hosts without one, ex. minimal containers.
+	_ "time/tzdata"
+)
+
+const (
+	// defaultTimezone is the timezone of the agencies covered by 511.org.
+	defaultTimezone = "America/Los_Angeles"
+)
+
+// holidayConfig configures the days that run on a weekend schedule. Dates are
+// formatted as 2006-01-02. If Source names a "schedule" source, the holidays
+// in its GTFS calendar_dates.txt are included too.
+type holidayConfig struct {
+	Dates  []string `json:"dates"`
+	Source string   `json:"source"`
+}
+
+// setupCalendar loads the timezone that refresh schedules are evaluated in and
+// the holidays that run on a weekend schedule. The timezone defaults to the
+// agency's timezone from a GTFS feed, if one is configured.
+func (m *Module) setupCalendar() error {
+	m.holidays = make(map[string]bool)
+	for _, date := range m.config.Holidays.Dates {
+		if _, err := time.Parse(scheduleDateLayout, date); err != nil {
+			return fmt.Errorf("Invalid holiday %q", date)
+		}
+		m.holidays[date] = true
+	}
+
+	var schedule *gtfsSchedulePredictor
+	if name := m.config.Holidays.Source; name != "" {
+		predictor, err := m.source(name, nil)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if schedule, ok = predictor.(*gtfsSchedulePredictor); !ok {
+			return fmt.Errorf("Holiday source %q isn't a GTFS schedule", name)
+		}
+		for _, date := range schedule.holidays() {
+			m.holidays[date] = true
+		}
+	}
+	for _, predictor := range m.predictors {
+		if s, ok := predictor.(*gtfsSchedulePredictor); ok && schedule == nil {
+			schedule = s
+		}
+	}
+
+	var err error
+	switch {
+	case m.config.Timezone != "":
+		m.location, err = time.LoadLocation(m.config.Timezone)
+	case schedule != nil && schedule.location != time.Local:
+		m.location = schedule.location
+	default:
+		m.location, err = time.LoadLocation(defaultTimezone)
+	}
+	return err
+}
+
+// localTime returns the time in the agency's timezone.
+func (m *Module) localTime(t time.Time) time.Time {
+	return t.In(m.location)
+}
+
+// weekday returns the day of the week, treating holidays as Sundays.
+func (m *Module) weekday(t time.Time) time.Weekday {
+	if m.holidays[t.Format(scheduleDateLayout)] {
+		return time.Sunday
+	}
+	return t.Weekday()
+}
@@ -0,0 +1,816 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/octavore/naga/service"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultCatalogURL    = "http://services.my511.org/Transit2.0/"
+	defaultCatalogMaxAge = 7 * 24 * time.Hour
+	defaultSearchLimit   = 20
+	// catalogCommandTimeout bounds how long the search command may take to
+	// fetch the catalog.
+	catalogCommandTimeout = 5 * time.Minute
+	// catalogCrawlTimeout bounds how long a search may spend fetching every
+	// route's stops. The fetch is shared by concurrent searches, so it isn't
+	// bound to any one of their requests.
+	catalogCrawlTimeout = 2 * time.Minute
+	// maxCatalogCrawl is the most routes' stops a search may fetch.
+	maxCatalogCrawl = 300
+)
+
+var (
+	// ErrNoCatalog is returned when there's no access token for the stop
+	// catalog.
+	ErrNoCatalog = errors.New("The stop catalog needs a 511.org access token in keys.json")
+	// ErrEmptyQuery is returned for a search without any words.
+	ErrEmptyQuery = errors.New("Empty search query")
+	// ErrCatalogBudget is returned when the access token's request budget
+	// can't spare a request for the catalog.
+	ErrCatalogBudget = errors.New("The 511.org request budget can't spare any requests for the stop catalog right now")
+	// ErrCatalogTooLarge is returned when a search would fetch the stops of
+	// more than maxCatalogCrawl routes.
+	ErrCatalogTooLarge = fmt.Errorf("Searching would fetch the stops of more than %d routes; limit catalog.agencies in config.json", maxCatalogCrawl)
+)
+
+// catalogConfig configures the catalog of 511.org agencies, routes and stops.
+// Key names the access token in keys.json, and defaults to "511.org". The
+// catalog is cached for MaxAge, and in CachePath if it's set, so that it's
+// kept between runs. Agencies limits searches to the given agencies.
+type catalogConfig struct {
+	URL       string   `json:"url"`
+	Key       string   `json:"key"`
+	MaxAge    duration `json:"max_age"`
+	CachePath string   `json:"cache_path"`
+	Agencies  []string `json:"agencies"`
+	upstreamConfig
+}
+
+// AgencyInfo describes a transit agency known to 511.org.
+type AgencyInfo struct {
+	Name         string `json:"name"`
+	HasDirection bool   `json:"has_direction"`
+	Mode         string `json:"mode"`
+}
+
+// DirectionInfo describes a direction of a route.
+type DirectionInfo struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// RouteInfo describes a route of an agency. Directions is empty for agencies
+// that don't distinguish directions.
+type RouteInfo struct {
+	Agency     string          `json:"agency"`
+	Code       string          `json:"code"`
+	Name       string          `json:"name"`
+	Directions []DirectionInfo `json:"directions,omitempty"`
+}
+
+// StopInfo describes a stop on a route. Its fields match those of Stop, so
+// that it can be copied into stops.json.
+type StopInfo struct {
+	Agency        string `json:"agency"`
+	Route         string `json:"route"`
+	RouteName     string `json:"route_name"`
+	Direction     string `json:"direction,omitempty"`
+	DirectionName string `json:"direction_name,omitempty"`
+	Name          string `json:"name"`
+	Code          int    `json:"code"`
+}
+
+// AmbiguousRouteError is returned when a route is looked up without an
+// agency, and several agencies run a route with its code.
+type AmbiguousRouteError struct {
+	Code     string
+	Agencies []string
+}
+
+func (e *AmbiguousRouteError) Error() string {
+	return fmt.Sprintf("Route %q is run by several agencies: %s", e.Code, strings.Join(e.Agencies, ", "))
+}
+
+// UpstreamError is returned when 511.org fails to answer a catalog request.
+type UpstreamError struct {
+	Err error
+}
+
+func (e *UpstreamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+type catalogAgency struct {
+	Name         string  `xml:"Name,attr"`
+	HasDirection string  `xml:"HasDirection,attr"`
+	Mode         string  `xml:"Mode,attr"`
+	Routes       []route `xml:"RouteList>Route"`
+}
+
+type catalogResponse struct {
+	Agencies []catalogAgency `xml:"AgencyList>Agency"`
+}
+
+// catalogData is everything fetched from 511.org so far. FetchedAt is when
+// the oldest of it was fetched.
+type catalogData struct {
+	FetchedAt time.Time    `json:"fetched_at"`
+	Agencies  []AgencyInfo `json:"agencies"`
+	// Routes maps agency names to their routes.
+	Routes map[string][]RouteInfo `json:"routes"`
+	// Stops maps route IDs, ex. "SF-MUNI~N~Inbound", to their stops.
+	Stops map[string][]StopInfo `json:"stops"`
+}
+
+// catalog fetches agencies, routes and stops from the 511.org API and caches
+// them.
+type catalog struct {
+	baseURL     string
+	accessToken string
+	client      *upstreamClient
+	maxAge      time.Duration
+	cachePath   string
+	agencies    []string
+	// allow, if set, is called before every request to 511.org, and fails
+	// it if the access token's budget can't spare it.
+	allow func() error
+	// searches deduplicates concurrent fetches of every stop.
+	searches singleflight.Group
+	// saveMu serializes writes of the cache to disk.
+	saveMu sync.Mutex
+
+	mu   sync.Mutex
+	data catalogData
+	// dirty is true if data has changed since it was last saved.
+	dirty bool
+}
+
+func newCatalog(c catalogConfig, token string, client *upstreamClient) *catalog {
+	cat := &catalog{
+		baseURL:     c.URL,
+		accessToken: token,
+		client:      client,
+		maxAge:      time.Duration(c.MaxAge),
+		cachePath:   c.CachePath,
+		agencies:    c.Agencies,
+	}
+	if cat.baseURL == "" {
+		cat.baseURL = defaultCatalogURL
+	}
+	if !strings.HasSuffix(cat.baseURL, "/") {
+		cat.baseURL += "/"
+	}
+	if cat.maxAge <= 0 {
+		cat.maxAge = defaultCatalogMaxAge
+	}
+	cat.reset()
+	if cat.cachePath != "" {
+		if err := cat.load(); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error loading stop catalog from %s: %s\n", cat.cachePath, err.Error())
+		}
+	}
+	return cat
+}
+
+func (c *catalog) reset() {
+	c.data = catalogData{
+		Routes: make(map[string][]RouteInfo),
+		Stops:  make(map[string][]StopInfo),
+	}
+}
+
+// fetched marks the catalog as changed by a fetch that just completed. The
+// caller must hold c.mu.
+func (c *catalog) fetched() {
+	if c.data.FetchedAt.IsZero() {
+		c.data.FetchedAt = time.Now()
+	}
+	c.dirty = true
+}
+
+// load reads the catalog cached on disk, unless it's expired.
+func (c *catalog) load() error {
+	b, err := ioutil.ReadFile(c.cachePath)
+	if err != nil {
+		return err
+	}
+	var data catalogData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	if data.FetchedAt.IsZero() || time.Since(data.FetchedAt) > c.maxAge || data.Routes == nil || data.Stops == nil {
+		return nil
+	}
+	c.data = data
+	return nil
+}
+
+// save caches the catalog on disk if it's changed, and if a cache path is
+// configured.
+func (c *catalog) save() {
+	if c.cachePath == "" {
+		return
+	}
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	b, err := json.Marshal(c.data)
+	c.dirty = false
+	c.mu.Unlock()
+
+	if err == nil {
+		err = ioutil.WriteFile(c.cachePath, b, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving stop catalog to %s: %s\n", c.cachePath, err.Error())
+	}
+}
+
+// expire clears the catalog once it's too old. The caller must hold c.mu.
+func (c *catalog) expire() {
+	if !c.data.FetchedAt.IsZero() && time.Since(c.data.FetchedAt) > c.maxAge {
+		c.reset()
+	}
+}
+
+// fetch makes a request to the given endpoint of the 511.org API.
+func (c *catalog) fetch(ctx context.Context, endpoint string, params url.Values) (*catalogResponse, error) {
+	l, err := url.Parse(c.baseURL + endpoint)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("token", c.accessToken)
+	l.RawQuery = params.Encode()
+	if c.allow != nil {
+		if err := c.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.client.do(ctx, http.MethodGet, l.String())
+	if err != nil {
+		return nil, &UpstreamError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &UpstreamError{fmt.Errorf("The 511.org API responded with a non-200 status code: %v", resp.StatusCode)}
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &UpstreamError{err}
+	}
+
+	var response catalogResponse
+	if err := xml.Unmarshal(b, &response); err != nil {
+		return nil, &UpstreamError{fmt.Errorf("Unable to parse %s response: %s", endpoint, err.Error())}
+	}
+	return &response, nil
+}
+
+// Agencies returns every agency known to 511.org.
+func (c *catalog) Agencies(ctx context.Context) ([]AgencyInfo, error) {
+	c.mu.Lock()
+	c.expire()
+	agencies := c.data.Agencies
+	c.mu.Unlock()
+	if agencies != nil {
+		return agencies, nil
+	}
+
+	response, err := c.fetch(ctx, "GetAgencies.aspx", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	agencies = make([]AgencyInfo, 0, len(response.Agencies))
+	for _, a := range response.Agencies {
+		agencies = append(agencies, AgencyInfo{
+			Name:         a.Name,
+			HasDirection: strings.EqualFold(a.HasDirection, "true"),
+			Mode:         a.Mode,
+		})
+	}
+
+	if len(agencies) > 0 {
+		c.mu.Lock()
+		c.data.Agencies = agencies
+		c.fetched()
+		c.mu.Unlock()
+	}
+	return agencies, nil
+}
+
+// Routes returns the routes of the agency.
+func (c *catalog) Routes(ctx context.Context, agency string) ([]RouteInfo, error) {
+	c.mu.Lock()
+	c.expire()
+	routes, ok := c.data.Routes[agency]
+	c.mu.Unlock()
+	if ok {
+		return routes, nil
+	}
+
+	response, err := c.fetch(ctx, "GetRoutesForAgency.aspx", url.Values{"agencyName": {agency}})
+	if err != nil {
+		return nil, err
+	}
+	routes = []RouteInfo{}
+	for _, a := range response.Agencies {
+		for _, r := range a.Routes {
+			info := RouteInfo{Agency: a.Name, Code: r.Code, Name: r.Name}
+			for _, d := range r.Directions {
+				info.Directions = append(info.Directions, DirectionInfo{Code: d.Code, Name: d.Name})
+			}
+			routes = append(routes, info)
+		}
+	}
+
+	// An unknown agency has no routes, and isn't cached in case it's a
+	// new one.
+	if len(routes) > 0 {
+		c.mu.Lock()
+		c.data.Routes[agency] = routes
+		c.fetched()
+		c.mu.Unlock()
+	}
+	return routes, nil
+}
+
+// Stops returns the stops of the route in the given direction, which should
+// be empty for agencies that don't distinguish directions.
+func (c *catalog) Stops(ctx context.Context, agency, routeCode, direction string) ([]StopInfo, error) {
+	routeID := strings.Join([]string{agency, routeCode}, "~")
+	if direction != "" {
+		routeID += "~" + direction
+	}
+
+	c.mu.Lock()
+	c.expire()
+	stops, ok := c.data.Stops[routeID]
+	c.mu.Unlock()
+	if ok {
+		return stops, nil
+	}
+
+	response, err := c.fetch(ctx, "GetStopsForRoute.aspx", url.Values{"routeIDF": {routeID}})
+	if err != nil {
+		return nil, err
+	}
+	stops = []StopInfo{}
+	for _, a := range response.Agencies {
+		for _, r := range a.Routes {
+			for _, s := range r.Stops {
+				stops = append(stops, StopInfo{Agency: a.Name, Route: r.Code, RouteName: r.Name, Name: s.Name, Code: s.Code})
+			}
+			for _, d := range r.Directions {
+				for _, s := range d.Stops {
+					stops = append(stops, StopInfo{
+						Agency:        a.Name,
+						Route:         r.Code,
+						RouteName:     r.Name,
+						Direction:     d.Code,
+						DirectionName: d.Name,
+						Name:          s.Name,
+						Code:          s.Code,
+					})
+				}
+			}
+		}
+	}
+
+	if len(stops) > 0 {
+		c.mu.Lock()
+		c.data.Stops[routeID] = stops
+		c.fetched()
+		c.mu.Unlock()
+	}
+	return stops, nil
+}
+
+// searchedAgencies returns the names of the configured agencies, or of every
+// agency if none are configured.
+func (c *catalog) searchedAgencies(ctx context.Context) ([]string, error) {
+	if len(c.agencies) > 0 {
+		return c.agencies, nil
+	}
+	all, err := c.Agencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var agencies []string
+	for _, a := range all {
+		agencies = append(agencies, a.Name)
+	}
+	return agencies, nil
+}
+
+// Route returns the route with the given code, and whether it exists. If no
+// agency is given, the route is looked up among the configured agencies, or
+// every agency, and must be unique, or an AmbiguousRouteError is returned.
+func (c *catalog) Route(ctx context.Context, agency, code string) (RouteInfo, bool, error) {
+	agencies := []string{agency}
+	if agency == "" {
+		var err error
+		if agencies, err = c.searchedAgencies(ctx); err != nil {
+			return RouteInfo{}, false, err
+		}
+	}
+
+	var found []RouteInfo
+	for _, a := range agencies {
+		routes, err := c.Routes(ctx, a)
+		if err != nil {
+			return RouteInfo{}, false, err
+		}
+		for _, r := range routes {
+			if strings.EqualFold(r.Code, code) {
+				found = append(found, r)
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return RouteInfo{}, false, nil
+	case 1:
+		return found[0], true, nil
+	}
+	err := &AmbiguousRouteError{Code: code}
+	for _, r := range found {
+		err.Agencies = append(err.Agencies, r.Agency)
+	}
+	return RouteInfo{}, false, err
+}
+
+// allStops returns the stops of every route of the configured agencies, or
+// of every agency if none are configured, and saves anything it fetched.
+// Concurrent calls share a single fetch, which carries on if the caller that
+// started it gives up.
+func (c *catalog) allStops(ctx context.Context) ([]StopInfo, error) {
+	results := c.searches.DoChan("", func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), catalogCrawlTimeout)
+		defer cancel()
+		stops, err := c.fetchAllStops(fetchCtx)
+		c.save()
+		return stops, err
+	})
+	select {
+	case res := <-results:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]StopInfo), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchAllStops fetches the stops of every searched route, several routes at
+// a time. It fails without fetching any if there are more than
+// maxCatalogCrawl routes whose stops aren't cached.
+func (c *catalog) fetchAllStops(ctx context.Context) ([]StopInfo, error) {
+	agencies, err := c.searchedAgencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type routeDirectionID struct {
+		agency, route, direction string
+	}
+	var ids []routeDirectionID
+	for _, agency := range agencies {
+		routes, err := c.Routes(ctx, agency)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range routes {
+			if len(r.Directions) == 0 {
+				ids = append(ids, routeDirectionID{agency, r.Code, ""})
+			}
+			for _, d := range r.Directions {
+				ids = append(ids, routeDirectionID{agency, r.Code, d.Code})
+			}
+		}
+	}
+
+	var uncached int
+	c.mu.Lock()
+	for _, id := range ids {
+		routeID := strings.Join([]string{id.agency, id.route}, "~")
+		if id.direction != "" {
+			routeID += "~" + id.direction
+		}
+		if _, ok := c.data.Stops[routeID]; !ok {
+			uncached++
+		}
+	}
+	c.mu.Unlock()
+	if uncached > maxCatalogCrawl {
+		return nil, ErrCatalogTooLarge
+	}
+
+	results := make([][]StopInfo, len(ids))
+	errs := make([]error, len(ids))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < refreshWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], errs[i] = c.Stops(ctx, ids[i].agency, ids[i].route, ids[i].direction)
+			}
+		}()
+	}
+	for i := range ids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var stops []StopInfo
+	for i := range ids {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		stops = append(stops, results[i]...)
+	}
+	return stops, nil
+}
+
+// Search returns up to limit stops whose names, routes or directions match
+// every word of the query, best matches first. Words match if they're
+// equal, prefixes, common abbreviations or, for words without numbers, off
+// by a letter, so "judah 22nd" and "jduah 22nd" find "Judah St and 22nd
+// Ave".
+func (c *catalog) Search(ctx context.Context, query string, limit int) ([]StopInfo, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	stops, err := c.allStops(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []stopMatch
+	for _, s := range stops {
+		nameWords := searchTerms(s.Name)
+		routeWords := searchTerms(strings.Join([]string{s.Route, s.RouteName, s.Direction, s.DirectionName}, " "))
+		if score, ok := matchTerms(terms, nameWords, routeWords); ok {
+			matches = append(matches, stopMatch{s, score})
+		}
+	}
+	sort.Sort(byScore(matches))
+
+	var res []StopInfo
+	for _, m := range matches {
+		if len(res) == limit {
+			break
+		}
+		res = append(res, m.stop)
+	}
+	return res, nil
+}
+
+var (
+	// searchAbbreviations maps words to the abbreviations 511.org uses.
+	searchAbbreviations = map[string]string{
+		"street":    "st",
+		"avenue":    "ave",
+		"boulevard": "blvd",
+		"drive":     "dr",
+		"inbound":   "ib",
+		"outbound":  "ob",
+	}
+)
+
+// searchTerms splits text into lowercase words, with punctuation and
+// abbreviations normalized.
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for i, w := range words {
+		if abbreviation, ok := searchAbbreviations[w]; ok {
+			words[i] = abbreviation
+		}
+	}
+	return words
+}
+
+// matchTerms scores how well a stop's name and route words match the query
+// terms. Every term must match a word, and matches in the name count for
+// more.
+func matchTerms(terms, nameWords, routeWords []string) (int, bool) {
+	var total int
+	for _, term := range terms {
+		best := matchTerm(term, routeWords)
+		if score := matchTerm(term, nameWords); score > 0 && score+1 > best {
+			best = score + 1
+		}
+		if best == 0 {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+// matchTerm scores how well the best of the words matches the term, or
+// returns 0 if none do.
+func matchTerm(term string, words []string) int {
+	var best int
+	for _, w := range words {
+		var score int
+		switch {
+		case w == term:
+			score = 3
+		case strings.HasPrefix(w, term):
+			score = 2
+		case len(term) >= 4 && !strings.ContainsAny(term, "0123456789") && editDistance(term, w) <= 1:
+			score = 1
+		}
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and transpositions of adjacent letters that turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j] + 1
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if d[i-1][j-1]+cost < d[i][j] {
+				d[i][j] = d[i-1][j-1] + cost
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+type stopMatch struct {
+	stop  StopInfo
+	score int
+}
+
+// byScore sorts stop matches from best to worst, then by name.
+type byScore []stopMatch
+
+func (s byScore) Len() int      { return len(s) }
+func (s byScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score > s[j].score
+	}
+	if s[i].stop.Name != s[j].stop.Name {
+		return s[i].stop.Name < s[j].stop.Name
+	}
+	return s[i].stop.Route < s[j].stop.Route
+}
+
+// setupCatalog builds the stop catalog, if there's an access token for it.
+func (m *Module) setupCatalog() {
+	c := m.config.Catalog
+	if c.Key == "" {
+		c.Key = "511.org"
+	}
+	token, ok := m.keys[c.Key]
+	if !ok {
+		return
+	}
+	client := newUpstreamClient(c.upstreamConfig.merge(m.config.Upstream))
+	if m.recorder != nil {
+		client.client.Transport = m.recorder.transport(client.client.Transport)
+	}
+	m.catalog = newCatalog(c, token, client)
+	m.catalog.allow = func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.quotas != nil && !m.quotas.take(c.Key, catalogBudgetShare, time.Now()) {
+			return ErrCatalogBudget
+		}
+		return nil
+	}
+}
+
+func (m *Module) stopCatalog() (*catalog, error) {
+	if m.catalog == nil {
+		return nil, ErrNoCatalog
+	}
+	return m.catalog, nil
+}
+
+// Agencies returns every agency known to 511.org.
+func (m *Module) Agencies(ctx context.Context) ([]AgencyInfo, error) {
+	c, err := m.stopCatalog()
+	if err != nil {
+		return nil, err
+	}
+	return c.Agencies(ctx)
+}
+
+// Routes returns the routes of the agency.
+func (m *Module) Routes(ctx context.Context, agency string) ([]RouteInfo, error) {
+	c, err := m.stopCatalog()
+	if err != nil {
+		return nil, err
+	}
+	return c.Routes(ctx, agency)
+}
+
+// Route returns the route with the given code, and whether it exists. If no
+// agency is given, it's looked up among every agency in the catalog.
+func (m *Module) Route(ctx context.Context, agency, code string) (RouteInfo, bool, error) {
+	c, err := m.stopCatalog()
+	if err != nil {
+		return RouteInfo{}, false, err
+	}
+	return c.Route(ctx, agency, code)
+}
+
+// RouteStops returns the stops of the agency's route in the given direction.
+func (m *Module) RouteStops(ctx context.Context, agency, route, direction string) ([]StopInfo, error) {
+	c, err := m.stopCatalog()
+	if err != nil {
+		return nil, err
+	}
+	return c.Stops(ctx, agency, route, direction)
+}
+
+// SearchStops returns up to limit stops that match the query, ex. "judah
+// 22nd", best matches first.
+func (m *Module) SearchStops(ctx context.Context, query string, limit int) ([]StopInfo, error) {
+	c, err := m.stopCatalog()
+	if err != nil {
+		return nil, err
+	}
+	return c.Search(ctx, query, limit)
+}
+
+// searchStopsCommand prints the stops that match the query given as
+// arguments, for finding stop codes to put in stops.json.
+func (m *Module) searchStopsCommand(ctx *service.CommandContext) {
+	query := strings.Join(ctx.Args, " ")
+	searchCtx, cancel := context.WithTimeout(context.Background(), catalogCommandTimeout)
+	defer cancel()
+
+	stops, err := m.SearchStops(searchCtx, query, defaultSearchLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error searching stops: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if len(stops) == 0 {
+		fmt.Printf("No stops match %q\n", query)
+		return
+	}
+	for _, s := range stops {
+		fmt.Printf("%6d  %-10s %-6s %-10s %s\n", s.Code, s.Agency, s.Route, s.Direction, s.Name)
+	}
+}
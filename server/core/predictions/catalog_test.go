@@ -0,0 +1,328 @@
+package predictions
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRoutesResponse = `<RTT><AgencyList><Agency Name="SF-MUNI" HasDirection="True" Mode="Bus"><RouteList>
+<Route Name="N-Judah" Code="N"><RouteDirectionList>
+<RouteDirection Code="Inbound" Name="Inbound to Caltrain via Downtown" />
+</RouteDirectionList></Route>
+</RouteList></Agency></AgencyList></RTT>`
+
+const emptyCatalogResponse = `<RTT><AgencyList></AgencyList></RTT>`
+
+// catalogServer stands in for the 511.org catalog endpoints. It knows the
+// SF-MUNI N-Judah's inbound stops, from the fixture, and "Sprawl", an agency
+// with more routes than a search may fetch. If gate is set, requests for
+// stops wait until it's closed.
+type catalogServer struct {
+	*httptest.Server
+	gate     chan struct{}
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newCatalogServer(t *testing.T) *catalogServer {
+	stops, err := ioutil.ReadFile("../../fixtures/stops.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &catalogServer{requests: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("token") != "secret" {
+			t.Errorf("got token %q", query.Get("token"))
+		}
+		s.mu.Lock()
+		s.requests[req.URL.Path+"?"+query.Get("agencyName")+query.Get("routeIDF")]++
+		s.mu.Unlock()
+
+		if req.URL.Path == "/GetStopsForRoute.aspx" && s.gate != nil {
+			<-s.gate
+		}
+		switch {
+		case req.URL.Path == "/GetRoutesForAgency.aspx" && query.Get("agencyName") == "SF-MUNI":
+			rw.Write([]byte(testRoutesResponse))
+		case req.URL.Path == "/GetRoutesForAgency.aspx" && query.Get("agencyName") == "Sprawl":
+			routes := make([]string, maxCatalogCrawl+1)
+			for i := range routes {
+				routes[i] = fmt.Sprintf(`<Route Name="%d" Code="%d" />`, i, i)
+			}
+			fmt.Fprintf(rw, `<RTT><AgencyList><Agency Name="Sprawl" HasDirection="False" Mode="Bus"><RouteList>%s</RouteList></Agency></AgencyList></RTT>`,
+				strings.Join(routes, ""))
+		case req.URL.Path == "/GetStopsForRoute.aspx" && query.Get("routeIDF") == "SF-MUNI~N~Inbound":
+			rw.Write(stops)
+		default:
+			rw.Write([]byte(emptyCatalogResponse))
+		}
+	}))
+	return s
+}
+
+func (s *catalogServer) count(request string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[request]
+}
+
+func TestCatalogStops(t *testing.T) {
+	server := newCatalogServer(t)
+	defer server.Close()
+	c := newCatalog(catalogConfig{URL: server.URL}, "secret", newUpstreamClient(upstreamConfig{}))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		stops, err := c.Stops(ctx, "SF-MUNI", "N", "Inbound")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stops) != 33 {
+			t.Fatalf("got %d stops, expected 33", len(stops))
+		}
+		expected := StopInfo{
+			Agency:        "SF-MUNI",
+			Route:         "N",
+			RouteName:     "N-Judah",
+			Direction:     "Inbound",
+			DirectionName: "Inbound to Caltrain via Downtown",
+			Name:          "9th Ave and Irving St",
+			Code:          13212,
+		}
+		if stops[0] != expected {
+			t.Errorf("got %+v, expected %+v", stops[0], expected)
+		}
+	}
+	if n := server.count("/GetStopsForRoute.aspx?SF-MUNI~N~Inbound"); n != 1 {
+		t.Errorf("fetched the stops %d times, expected them to be cached", n)
+	}
+
+	// Unknown routes and agencies aren't cached, in case they're added.
+	for i := 0; i < 2; i++ {
+		stops, err := c.Stops(ctx, "SF-MUNI", "N", "Outbound")
+		if err != nil || len(stops) != 0 {
+			t.Errorf("got %d stops and error %v for an unknown route", len(stops), err)
+		}
+		routes, err := c.Routes(ctx, "Muni")
+		if err != nil || len(routes) != 0 {
+			t.Errorf("got %d routes and error %v for an unknown agency", len(routes), err)
+		}
+	}
+	if n := server.count("/GetStopsForRoute.aspx?SF-MUNI~N~Outbound"); n != 2 {
+		t.Errorf("fetched an unknown route's stops %d times, expected 2", n)
+	}
+	if n := server.count("/GetRoutesForAgency.aspx?Muni"); n != 2 {
+		t.Errorf("fetched an unknown agency's routes %d times, expected 2", n)
+	}
+}
+
+func TestCatalogSearch(t *testing.T) {
+	server := newCatalogServer(t)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := catalogConfig{URL: server.URL, Agencies: []string{"SF-MUNI"}, CachePath: filepath.Join(dir, "catalog.json")}
+	c := newCatalog(config, "secret", newUpstreamClient(upstreamConfig{}))
+
+	queries := []string{"judah 22nd", "jduah 22nd", "Judah Street & 22nd Avenue", "22nd judah inbound"}
+	var wg sync.WaitGroup
+	for _, query := range queries {
+		wg.Add(1)
+		go func(query string) {
+			defer wg.Done()
+			stops, err := c.Search(context.Background(), query, 5)
+			if err != nil {
+				t.Errorf("%s: %s", query, err)
+				return
+			}
+			if len(stops) != 1 || stops[0].Code != 15201 {
+				t.Errorf("%s: got %+v, expected Judah St and 22nd Ave", query, stops)
+			}
+		}(query)
+	}
+	wg.Wait()
+	if n := server.count("/GetStopsForRoute.aspx?SF-MUNI~N~Inbound"); n != 1 {
+		t.Errorf("fetched the stops %d times, expected 1", n)
+	}
+
+	// The catalog is saved, and a new one searches it without going to
+	// 511.org.
+	server.Close()
+	cached := newCatalog(config, "secret", newUpstreamClient(upstreamConfig{}))
+	stops, err := cached.Search(context.Background(), "judah 22", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stops) != 1 || stops[0].Code != 15201 {
+		t.Errorf("got %+v from the cached catalog, expected Judah St and 22nd Ave", stops)
+	}
+}
+
+func TestCatalogFetchedAt(t *testing.T) {
+	server := newCatalogServer(t)
+	defer server.Close()
+	c := newCatalog(catalogConfig{URL: server.URL}, "secret", newUpstreamClient(upstreamConfig{}))
+	if !c.data.FetchedAt.IsZero() {
+		t.Errorf("got fetched at %s before fetching anything", c.data.FetchedAt)
+	}
+
+	before := time.Now()
+	if _, err := c.Routes(context.Background(), "SF-MUNI"); err != nil {
+		t.Fatal(err)
+	}
+	fetchedAt := c.data.FetchedAt
+	if fetchedAt.Before(before) {
+		t.Errorf("got fetched at %s, expected it to be after %s", fetchedAt, before)
+	}
+
+	// Later fetches don't make older data look newer.
+	if _, err := c.Stops(context.Background(), "SF-MUNI", "N", "Inbound"); err != nil {
+		t.Fatal(err)
+	}
+	if !c.data.FetchedAt.Equal(fetchedAt) {
+		t.Errorf("got fetched at %s after another fetch, expected %s", c.data.FetchedAt, fetchedAt)
+	}
+
+	// Once it expires, the catalog is fetched again.
+	c.data.FetchedAt = time.Now().Add(-2 * defaultCatalogMaxAge)
+	if _, err := c.Routes(context.Background(), "SF-MUNI"); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.count("/GetRoutesForAgency.aspx?SF-MUNI"); n != 2 {
+		t.Errorf("fetched the routes %d times, expected 2", n)
+	}
+}
+
+func TestCatalogSearchOutlivesCaller(t *testing.T) {
+	server := newCatalogServer(t)
+	server.gate = make(chan struct{})
+	defer server.Close()
+	c := newCatalog(catalogConfig{URL: server.URL, Agencies: []string{"SF-MUNI"}}, "secret", newUpstreamClient(upstreamConfig{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.Search(ctx, "judah 22nd", 5)
+		first <- err
+	}()
+	for server.count("/GetStopsForRoute.aspx?SF-MUNI~N~Inbound") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan []StopInfo)
+	go func() {
+		stops, err := c.Search(context.Background(), "judah 22nd", 5)
+		if err != nil {
+			t.Error(err)
+		}
+		second <- stops
+	}()
+
+	// The first caller gives up, but the search it started carries on for
+	// the second.
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("got %v for the cancelled search, expected it to be cancelled", err)
+	}
+	close(server.gate)
+	if stops := <-second; len(stops) != 1 || stops[0].Code != 15201 {
+		t.Errorf("got %+v, expected Judah St and 22nd Ave", stops)
+	}
+	if n := server.count("/GetStopsForRoute.aspx?SF-MUNI~N~Inbound"); n != 1 {
+		t.Errorf("fetched the stops %d times, expected 1", n)
+	}
+}
+
+func TestCatalogSearchLimits(t *testing.T) {
+	server := newCatalogServer(t)
+	defer server.Close()
+
+	c := newCatalog(catalogConfig{URL: server.URL, Agencies: []string{"Sprawl"}}, "secret", newUpstreamClient(upstreamConfig{}))
+	if _, err := c.Search(context.Background(), "judah", 5); err != ErrCatalogTooLarge {
+		t.Errorf("got %v, expected ErrCatalogTooLarge", err)
+	}
+	if n := server.count("/GetStopsForRoute.aspx?Sprawl~0"); n != 0 {
+		t.Errorf("fetched a route's stops %d times, expected none", n)
+	}
+
+	// The budget allows the routes, but not their stops.
+	c = newCatalog(catalogConfig{URL: server.URL, Agencies: []string{"SF-MUNI"}}, "secret", newUpstreamClient(upstreamConfig{}))
+	requests := 0
+	c.allow = func() error {
+		if requests++; requests > 1 {
+			return ErrCatalogBudget
+		}
+		return nil
+	}
+	if _, err := c.Search(context.Background(), "judah", 5); err != ErrCatalogBudget {
+		t.Errorf("got %v, expected ErrCatalogBudget", err)
+	}
+	if n := server.count("/GetStopsForRoute.aspx?SF-MUNI~N~Inbound"); n != 0 {
+		t.Errorf("fetched the stops %d times over budget, expected none", n)
+	}
+}
+
+func TestMatchTerms(t *testing.T) {
+	testCases := []struct {
+		query, name, route string
+		match              bool
+	}{
+		{"judah 22nd", "Judah St and 22nd Ave", "N N-Judah Inbound", true},
+		{"jduah 22nd", "Judah St and 22nd Ave", "N N-Judah Inbound", true},
+		{"juda 22nd", "Judah St and 22nd Ave", "N N-Judah Inbound", true},
+		{"judah 22", "Judah St and 22nd Ave", "N N-Judah Inbound", true},
+		{"judah street", "Judah St and 22nd Ave", "N N-Judah Inbound", true},
+		{"judah inbound", "Judah St and 22nd Ave", "N N-Judah IB", true},
+		// Words with numbers must match exactly or by prefix.
+		{"judah 22nd", "Judah St and 2nd Ave", "N N-Judah Inbound", false},
+		{"judah 23rd", "Judah St and 22nd Ave", "N N-Judah Inbound", false},
+		// Short words must too.
+		{"jda", "Judah St and 22nd Ave", "N N-Judah Inbound", false},
+		{"judah church", "Judah St and 22nd Ave", "N N-Judah Inbound", false},
+	}
+	for _, tc := range testCases {
+		_, ok := matchTerms(searchTerms(tc.query), searchTerms(tc.name), searchTerms(tc.route))
+		if ok != tc.match {
+			t.Errorf("%q against %q: got %t, expected %t", tc.query, tc.name, ok, tc.match)
+		}
+	}
+
+	exact, _ := matchTerms(searchTerms("judah 22nd"), searchTerms("Judah St and 22nd Ave"), nil)
+	typo, _ := matchTerms(searchTerms("jduah 22nd"), searchTerms("Judah St and 22nd Ave"), nil)
+	route, _ := matchTerms(searchTerms("judah 22nd"), searchTerms("22nd Ave and Irving St"), searchTerms("N N-Judah"))
+	if !(exact > typo && exact > route) {
+		t.Errorf("got scores %d for an exact match, %d for a typo and %d for a route match", exact, typo, route)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"judah", "judah", 0},
+		{"jduah", "judah", 1},
+		{"juda", "judah", 1},
+		{"jubah", "judah", 1},
+		{"judahh", "judah", 1},
+		{"hadju", "judah", 4},
+		{"", "judah", 5},
+	}
+	for _, tc := range testCases {
+		if d := editDistance(tc.a, tc.b); d != tc.distance {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", tc.a, tc.b, d, tc.distance)
+		}
+	}
+}
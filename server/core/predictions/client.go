@@ -0,0 +1,80 @@
+package predictions
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultUpstreamTimeout = 10 * time.Second
+	defaultConnectTimeout  = 5 * time.Second
+	defaultUserAgent       = "muni-display"
+)
+
+// upstreamConfig configures requests to upstream sources. It's set for every
+// source under "upstream" in config.json, and any of it may be overridden in a
+// source's options.
+type upstreamConfig struct {
+	UserAgent      string   `json:"user_agent"`
+	Timeout        duration `json:"timeout"`
+	ConnectTimeout duration `json:"connect_timeout"`
+}
+
+// merge returns the config with anything that's unset taken from defaults.
+func (c upstreamConfig) merge(defaults upstreamConfig) upstreamConfig {
+	if c.UserAgent == "" {
+		c.UserAgent = defaults.UserAgent
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = defaults.ConnectTimeout
+	}
+	return c
+}
+
+// upstreamClient makes requests to an upstream source. Every request has a
+// timeout, so that a hung request can't stall refreshes.
+type upstreamClient struct {
+	client    *http.Client
+	userAgent string
+}
+
+func newUpstreamClient(c upstreamConfig) *upstreamClient {
+	c = c.merge(upstreamConfig{
+		UserAgent:      defaultUserAgent,
+		Timeout:        duration(defaultUpstreamTimeout),
+		ConnectTimeout: duration(defaultConnectTimeout),
+	})
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   time.Duration(c.ConnectTimeout),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: time.Duration(c.ConnectTimeout),
+		MaxIdleConnsPerHost: refreshWorkers,
+	}
+	return &upstreamClient{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(c.Timeout),
+		},
+		userAgent: c.UserAgent,
+	}
+}
+
+// do makes a request that's cancelled once the context is done.
+func (c *upstreamClient) do(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	return c.client.Do(req)
+}
@@ -0,0 +1,93 @@
+package predictions
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type predictionsConfig struct {
+	RouteVariants routeVariants `json:"route_variants"`
+	// Sources maps source names, ex. "gtfs-rt:muni", to their options.
+	Sources map[string]json.RawMessage `json:"sources"`
+	Breaker breakerConfig              `json:"breaker"`
+	// Quotas maps names of access tokens in keys.json to their budgets.
+	Quotas map[string]quotaConfig `json:"quotas"`
+	// Schedule is the refresh schedule of stops that don't have their own.
+	Schedule []ScheduleRule `json:"schedule"`
+	// Timezone is the IANA timezone that schedules are evaluated in.
+	Timezone string        `json:"timezone"`
+	Holidays holidayConfig `json:"holidays"`
+	Demand   demandConfig  `json:"demand"`
+	// Upstream configures requests to every source.
+	Upstream  upstreamConfig  `json:"upstream"`
+	Recording recordingConfig `json:"recording"`
+	History   historyConfig   `json:"history"`
+	Headways  headwayConfig   `json:"headways"`
+	Catalog   catalogConfig   `json:"catalog"`
+}
+
+// feedConfig configures where to find a feed, for the "gtfs-rt" and
+// "schedule" sources. If Key is set, the access token with that name in
+// keys.json is appended to the feed URL as api_key.
+type feedConfig struct {
+	Feed string `json:"feed"`
+	Key  string `json:"key"`
+	upstreamConfig
+}
+
+// legacy511Config configures the "511.org" source. URL is the base URL of the
+// API, which defaults to services.my511.org, and Key names the access token
+// in keys.json.
+type legacy511Config struct {
+	URL string `json:"url"`
+	Key string `json:"key"`
+	upstreamConfig
+}
+
+// siriConfig configures the "511-siri" source. Agencies maps agency names used
+// in stops.json to SIRI operator codes, in addition to the built-in ones.
+type siriConfig struct {
+	feedConfig
+	Agencies map[string]string `json:"agencies"`
+}
+
+// fallbackConfig configures a "fallback" source: a chain of sources, tried in
+// order. A source is skipped for Cooldown after FailureThreshold consecutive
+// failures.
+type fallbackConfig struct {
+	Sources          []string `json:"sources"`
+	FailureThreshold int      `json:"failure_threshold"`
+	Cooldown         duration `json:"cooldown"`
+}
+
+// ensembleConfig configures an "ensemble" source: a set of sources that are
+// queried together. Predictions of the same departure are matched up if
+// they're within Tolerance of each other, and conflicts are resolved using
+// Policy: one of "freshest", "median" or "prefer", which uses Prefer's
+// predictions.
+type ensembleConfig struct {
+	Sources   []string `json:"sources"`
+	Policy    string   `json:"policy"`
+	Prefer    string   `json:"prefer"`
+	Tolerance duration `json:"tolerance"`
+}
+
+// duration is a time.Duration that's configured as a string, ex. "5m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
@@ -0,0 +1,113 @@
+package predictions
+
+import "time"
+
+const (
+	defaultIdleAfter    = 10 * time.Minute
+	defaultIdleInterval = 5 * time.Minute
+	defaultRequestWait  = 3 * time.Second
+)
+
+// demandConfig configures how stops that no client is watching are refreshed.
+// A stop is idle once it hasn't been requested for IdleAfter. Idle stops are
+// refreshed every IdleInterval, or not at all if Pause is set. The first
+// request for an idle stop refreshes it immediately and waits up to Wait for
+// the new predictions.
+type demandConfig struct {
+	IdleAfter    duration `json:"idle_after"`
+	IdleInterval duration `json:"idle_interval"`
+	Pause        bool     `json:"pause"`
+	Wait         duration `json:"wait"`
+}
+
+func (c *demandConfig) setDefaults() {
+	if c.IdleAfter <= 0 {
+		c.IdleAfter = duration(defaultIdleAfter)
+	}
+	if c.IdleInterval <= 0 {
+		c.IdleInterval = duration(defaultIdleInterval)
+	}
+	if c.Wait <= 0 {
+		c.Wait = duration(defaultRequestWait)
+	}
+}
+
+// idle returns true if no client has requested the stop recently. The caller
+// must hold m.mu.
+func (m *Module) idle(key string, now time.Time) bool {
+	return now.Sub(m.lastRequested[key]) >= time.Duration(m.config.Demand.IdleAfter)
+}
+
+// idleDue returns true if the idle stop should be refreshed now. The caller
+// must hold m.mu.
+func (m *Module) idleDue(key string, now time.Time) bool {
+	if m.config.Demand.Pause {
+		return false
+	}
+	return now.Sub(m.stopStatuses[key].LastAttempt) >= time.Duration(m.config.Demand.IdleInterval)
+}
+
+// Request records that a client asked for the stop's predictions. If the stop
+// was idle, it's refreshed immediately and Request waits for the refresh up to
+// the configured deadline, unless too many idle stops are already being
+// refreshed, in which case it's left to the next check. It also waits for a
+// refresh that's already under way.
+func (m *Module) Request(key string) {
+	now := time.Now()
+
+	m.mu.Lock()
+	if _, ok := m.stops[key]; !ok {
+		m.mu.Unlock()
+		return
+	}
+	idle := m.idle(key, now)
+	m.lastRequested[key] = now
+	done, refreshing := m.refreshing[key]
+	if !refreshing && idle && m.demandRefreshes < maxDemandRefreshes && m.allowRefresh(key, m.localTime(now)) {
+		done = m.beginRefresh(key)
+		m.demandRefreshes++
+		go m.refreshOnDemand(key)
+	}
+	m.mu.Unlock()
+
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Duration(m.config.Demand.Wait)):
+	}
+}
+
+// refreshOnDemand refreshes an idle stop that a client requested.
+func (m *Module) refreshOnDemand(key string) {
+	m.refreshStop(key)
+	m.mu.Lock()
+	m.demandRefreshes--
+	m.mu.Unlock()
+}
+
+// LastRequested returns when a client last requested the stop's predictions.
+func (m *Module) LastRequested(key string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastRequested[key]
+}
+
+// beginRefresh marks the stop as being refreshed, and returns a channel that's
+// closed once it's done. The caller must hold m.mu.
+func (m *Module) beginRefresh(key string) chan struct{} {
+	done := make(chan struct{})
+	m.refreshing[key] = done
+	return done
+}
+
+// endRefresh marks the stop as no longer being refreshed.
+func (m *Module) endRefresh(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if done, ok := m.refreshing[key]; ok {
+		close(done)
+		delete(m.refreshing, key)
+	}
+}
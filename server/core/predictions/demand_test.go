@@ -0,0 +1,96 @@
+package predictions
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRequestRefreshesIdleStop(t *testing.T) {
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "511.org"},
+	}, map[string]Predictor{"511.org": &stubPredictor{predictions: []Prediction{{Minutes: 4, RouteCode: "N"}}}})
+	m.lastRequested["judah"] = time.Time{}
+	if !m.Status("judah").Idle {
+		t.Fatal("expected a stop that was never requested to be idle")
+	}
+
+	// The first request refreshes the idle stop and waits for it.
+	m.Request("judah")
+	status := m.Status("judah")
+	if status.Idle || status.LastSuccess.IsZero() || len(m.Current("judah")) != 1 {
+		t.Fatalf("expected the requested stop to be refreshed and active, got %+v", status)
+	}
+
+	// Requests for an active stop leave it to its schedule.
+	m.Request("judah")
+	if attempt := m.Status("judah").LastAttempt; !attempt.Equal(status.LastAttempt) {
+		t.Errorf("expected no refresh of the active stop, last attempted at %s", attempt)
+	}
+
+	// Once it's gone unrequested for long enough, it's idle again.
+	m.mu.Lock()
+	m.lastRequested["judah"] = time.Now().Add(-time.Duration(m.config.Demand.IdleAfter))
+	m.mu.Unlock()
+	if !m.Status("judah").Idle {
+		t.Fatal("expected the unrequested stop to be idle")
+	}
+	m.Request("judah")
+	if attempt := m.Status("judah").LastAttempt; !attempt.After(status.LastAttempt) {
+		t.Errorf("expected the idle stop to be refreshed again, last attempted at %s", attempt)
+	}
+}
+
+func TestRequestBoundsDemandRefreshes(t *testing.T) {
+	slow := &blockingPredictor{release: make(chan struct{})}
+	stops := make(map[string]Stop)
+	for i := 0; i < maxDemandRefreshes+2; i++ {
+		stops[fmt.Sprintf("stop-%d", i)] = Stop{Route: "N", Code: 15201 + i, Source: "slow"}
+	}
+	m := newTestModule(t, stops, map[string]Predictor{"slow": slow})
+	m.config.Demand.Wait = duration(time.Millisecond)
+	for k := range stops {
+		m.intervals[k] = interval(time.Hour)
+		m.lastRequested[k] = time.Time{}
+	}
+
+	for k := range stops {
+		m.Request(k)
+	}
+	m.mu.Lock()
+	refreshing, demand := len(m.refreshing), m.demandRefreshes
+	m.mu.Unlock()
+	if refreshing != maxDemandRefreshes || demand != maxDemandRefreshes {
+		t.Errorf("got %d stops refreshing, %d on demand, expected %d", refreshing, demand, maxDemandRefreshes)
+	}
+	for k := range stops {
+		if m.Status(k).Idle {
+			t.Errorf("expected %s to be active once requested", k)
+		}
+	}
+
+	close(slow.release)
+	waitFor(t, "the refreshes to finish", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.demandRefreshes == 0 && len(m.refreshing) == 0
+	})
+
+	// The stops that weren't refreshed on request are due on the next check.
+	if due := m.dueStops(time.Now()); len(due) != len(stops)-maxDemandRefreshes {
+		t.Errorf("got %d stops due, expected %d", len(due), len(stops)-maxDemandRefreshes)
+	}
+}
+
+func TestStartMarksStopsRequested(t *testing.T) {
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "511.org"},
+	}, map[string]Predictor{"511.org": &stubPredictor{}})
+	m.lastRequested["judah"] = time.Time{}
+	m.start()
+	t.Cleanup(m.stop)
+
+	if m.Status("judah").Idle {
+		t.Error("expected stops not to be idle when the module starts")
+	}
+}
@@ -0,0 +1,255 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// policyFreshest resolves conflicts using the prediction from the most
+	// recently recorded upstream data. Predictions that don't say when
+	// they were recorded are the oldest.
+	policyFreshest = "freshest"
+	// policyPrefer resolves conflicts using the preferred source's prediction
+	// if it has one, and otherwise the highest priority source's.
+	policyPrefer = "prefer"
+	// policyMedian resolves conflicts using the median departure time.
+	policyMedian = "median"
+
+	defaultMatchTolerance = 2 * time.Minute
+)
+
+// ensemblePredictor queries several sources at once and merges their
+// predictions. Predictions of the same departure are matched up by trip or
+// vehicle, or failing that by how close their departure times are.
+type ensemblePredictor struct {
+	name       string
+	sources    []string
+	predictors []Predictor
+	policy     string
+	preferred  string
+	tolerance  time.Duration
+}
+
+var _ Predictor = &ensemblePredictor{}
+
+// newEnsembleSource builds an ensemblePredictor from the sources in its
+// options.
+func newEnsembleSource(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	var o ensembleConfig
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	predictors, err := c.sources(o.Sources)
+	if err != nil {
+		return nil, err
+	}
+	return newEnsemblePredictor(c.name, o.Sources, predictors, o.Policy, o.Prefer, time.Duration(o.Tolerance))
+}
+
+func newEnsemblePredictor(name string, sources []string, predictors []Predictor, policy, preferred string, tolerance time.Duration) (*ensemblePredictor, error) {
+	switch policy {
+	case "":
+		policy = policyFreshest
+	case policyFreshest, policyMedian:
+	case policyPrefer:
+		var found bool
+		for _, source := range sources {
+			found = found || source == preferred
+		}
+		if !found {
+			return nil, fmt.Errorf("Ensemble %q prefers %q, which isn't one of its sources", name, preferred)
+		}
+	default:
+		return nil, fmt.Errorf("Ensemble %q has unknown policy %q", name, policy)
+	}
+	if tolerance <= 0 {
+		tolerance = defaultMatchTolerance
+	}
+	return &ensemblePredictor{
+		name:       name,
+		sources:    sources,
+		predictors: predictors,
+		policy:     policy,
+		preferred:  preferred,
+		tolerance:  tolerance,
+	}, nil
+}
+
+// ensembleMatch is a set of predictions from different sources that are
+// believed to describe the same departure.
+type ensembleMatch struct {
+	members []Prediction
+}
+
+func (e *ensemblePredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	results := make([][]Prediction, len(e.predictors))
+	errs := make([]error, len(e.predictors))
+
+	var wg sync.WaitGroup
+	for i, predictor := range e.predictors {
+		wg.Add(1)
+		go func(i int, predictor Predictor) {
+			defer wg.Done()
+			results[i], errs[i] = predictor.Predict(ctx, stop)
+		}(i, predictor)
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", e.sources[i], err.Error()))
+		}
+	}
+	if len(failures) == len(e.predictors) {
+		return nil, fmt.Errorf("All prediction sources failed: %s", strings.Join(failures, "; "))
+	}
+
+	for i := range results {
+		fillDepartureTimes(results[i])
+		for j := range results[i] {
+			results[i][j].Source = e.sources[i]
+		}
+	}
+	return e.merge(results, clock(ctx)), nil
+}
+
+// merge groups the predictions from each source into matches and resolves
+// each match into a single prediction.
+func (e *ensemblePredictor) merge(results [][]Prediction, now time.Time) []Prediction {
+	var matches []*ensembleMatch
+	for _, predictions := range results {
+		for _, p := range predictions {
+			if match := e.find(matches, p); match != nil {
+				match.members = append(match.members, p)
+			} else {
+				matches = append(matches, &ensembleMatch{members: []Prediction{p}})
+			}
+		}
+	}
+
+	merged := make([]Prediction, 0, len(matches))
+	for _, match := range matches {
+		merged = append(merged, e.resolve(match, now))
+	}
+	return merged
+}
+
+// find returns the match that the prediction belongs to, or nil if it's the
+// first prediction of its departure.
+func (e *ensemblePredictor) find(matches []*ensembleMatch, p Prediction) *ensembleMatch {
+	var closest *ensembleMatch
+	var closestDiff time.Duration
+	for _, match := range matches {
+		if match.has(p.Source) {
+			continue
+		}
+		for _, member := range match.members {
+			if p.TripID != "" && member.TripID == p.TripID {
+				return match
+			}
+			if p.VehicleID != "" && member.VehicleID == p.VehicleID {
+				return match
+			}
+		}
+
+		first := match.members[0]
+		if p.RouteCode != "" && first.RouteCode != "" && !strings.EqualFold(p.RouteCode, first.RouteCode) {
+			continue
+		}
+		diff := p.DepartsAt.Sub(first.DepartsAt)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= e.tolerance && (closest == nil || diff < closestDiff) {
+			closest, closestDiff = match, diff
+		}
+	}
+	return closest
+}
+
+func (m *ensembleMatch) has(source string) bool {
+	for _, member := range m.members {
+		if member.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve picks a single prediction for the match using the ensemble's
+// policy.
+func (e *ensemblePredictor) resolve(match *ensembleMatch, now time.Time) Prediction {
+	res := match.members[0]
+	switch e.policy {
+	case policyFreshest:
+		for _, member := range match.members[1:] {
+			if member.RecordedAt.After(res.RecordedAt) {
+				res = member
+			}
+		}
+	case policyPrefer:
+		for _, member := range match.members {
+			if member.Source == e.preferred {
+				res = member
+			}
+		}
+	case policyMedian:
+		times := make([]time.Time, 0, len(match.members))
+		for _, member := range match.members {
+			times = append(times, member.DepartsAt)
+			if member.CreatedAt.After(res.CreatedAt) {
+				res.CreatedAt = member.CreatedAt
+			}
+			if member.RecordedAt.After(res.RecordedAt) {
+				res.RecordedAt = member.RecordedAt
+			}
+		}
+		sort.Sort(byTime(times))
+		median := times[len(times)/2]
+		if len(times)%2 == 0 {
+			lower := times[len(times)/2-1]
+			median = lower.Add(median.Sub(lower) / 2)
+		}
+		res.DepartsAt = median
+		res.Minutes = int(median.Sub(now) / time.Minute)
+		if len(match.members) > 1 {
+			res.Source = e.name
+		}
+	}
+
+	// Fill in anything the chosen source doesn't know from the others.
+	for _, member := range match.members {
+		if res.TripID == "" {
+			res.TripID = member.TripID
+		}
+		if res.VehicleID == "" {
+			res.VehicleID = member.VehicleID
+		}
+		if res.RouteName == "" {
+			res.RouteName = member.RouteName
+		}
+		if res.DirectionName == "" {
+			res.DirectionName = member.DirectionName
+		}
+	}
+
+	res.Sources = nil
+	for _, member := range match.members {
+		res.Sources = append(res.Sources, member.Source)
+	}
+	return res
+}
+
+// byTime sorts times in ascending order.
+type byTime []time.Time
+
+func (t byTime) Len() int           { return len(t) }
+func (t byTime) Less(i, j int) bool { return t[i].Before(t[j]) }
+func (t byTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
@@ -0,0 +1,71 @@
+package predictions
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// stubPredictor returns the same predictions every time.
+type stubPredictor struct {
+	predictions []Prediction
+	err         error
+}
+
+func (s *stubPredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return append([]Prediction{}, s.predictions...), nil
+}
+
+func TestEnsembleFreshest(t *testing.T) {
+	now := time.Date(2016, 6, 30, 16, 43, 20, 0, time.UTC)
+	departsAt := func(minutes int, seconds int) time.Time {
+		return now.Add(time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second)
+	}
+	// The SIRI data was recorded after the GTFS-realtime feed, though the
+	// GTFS-realtime prediction was created last. The legacy API doesn't say
+	// when its data was recorded.
+	siri := &stubPredictor{predictions: []Prediction{
+		{CreatedAt: now, RecordedAt: now.Add(-15 * time.Second), DepartsAt: departsAt(2, 10), Minutes: 2, TripID: "11239834"},
+		{CreatedAt: now, RecordedAt: now.Add(-90 * time.Second), DepartsAt: departsAt(7, 20), Minutes: 7, TripID: "11239835"},
+	}}
+	gtfsRealtime := &stubPredictor{predictions: []Prediction{
+		{CreatedAt: now.Add(time.Second), RecordedAt: now.Add(-45 * time.Second), DepartsAt: departsAt(2, 40), Minutes: 2, TripID: "11239834"},
+		{CreatedAt: now.Add(time.Second), RecordedAt: now.Add(-45 * time.Second), DepartsAt: departsAt(8, 0), Minutes: 8, TripID: "11239835"},
+	}}
+	legacy := &stubPredictor{predictions: []Prediction{
+		{CreatedAt: now.Add(2 * time.Second), DepartsAt: departsAt(3, 0), Minutes: 3},
+		{CreatedAt: now.Add(2 * time.Second), DepartsAt: departsAt(14, 0), Minutes: 14},
+	}}
+
+	e, err := newEnsemblePredictor("ensemble", []string{"511.org", "511-siri", "gtfs-rt"},
+		[]Predictor{legacy, siri, gtfsRealtime}, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withClock(context.Background(), func() time.Time { return now })
+	predictions, err := e.Predict(ctx, &Stop{Route: "N", Code: 15201})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		source    string
+		departsAt time.Time
+	}{
+		{"511-siri", departsAt(2, 10)},
+		{"511.org", departsAt(14, 0)},
+		{"gtfs-rt", departsAt(8, 0)},
+	}
+	if len(predictions) != len(expected) {
+		t.Fatalf("got %d predictions, expected %d: %+v", len(predictions), len(expected), predictions)
+	}
+	for i, p := range predictions {
+		if p.Source != expected[i].source || !p.DepartsAt.Equal(expected[i].departsAt) {
+			t.Errorf("prediction %d: got %s at %s, expected %s at %s",
+				i, p.Source, p.DepartsAt, expected[i].source, expected[i].departsAt)
+		}
+	}
+}
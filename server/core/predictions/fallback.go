@@ -0,0 +1,154 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultCooldown         = 5 * time.Minute
+)
+
+// fallbackPredictor tries each of its sources in priority order and returns
+// the predictions from the first source that has any. Sources that fail
+// repeatedly are skipped for a cool-down period so that a dead feed doesn't
+// slow down every refresh.
+type fallbackPredictor struct {
+	name             string
+	sources          []string
+	predictors       []Predictor
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu         sync.Mutex
+	lastSource string
+	failures   []int
+	skipUntil  []time.Time
+}
+
+var _ Predictor = &fallbackPredictor{}
+
+// newFallbackSource builds a fallbackPredictor from the sources in its
+// options.
+func newFallbackSource(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	var o fallbackConfig
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	predictors, err := c.sources(o.Sources)
+	if err != nil {
+		return nil, err
+	}
+	return newFallbackPredictor(c.name, o.Sources, predictors, o.FailureThreshold, time.Duration(o.Cooldown)), nil
+}
+
+func newFallbackPredictor(name string, sources []string, predictors []Predictor, failureThreshold int, cooldown time.Duration) *fallbackPredictor {
+	if failureThreshold <= 0 {
+		failureThreshold = defaultFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultCooldown
+	}
+	return &fallbackPredictor{
+		name:             name,
+		sources:          sources,
+		predictors:       predictors,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		failures:         make([]int, len(predictors)),
+		skipUntil:        make([]time.Time, len(predictors)),
+	}
+}
+
+func (f *fallbackPredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	var errs []string
+	var answered bool
+	for i, predictor := range f.predictors {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if f.coolingDown(i, time.Now()) {
+			continue
+		}
+
+		predictions, err := predictor.Predict(ctx, stop)
+		// A source isn't to blame for running out of time or being
+		// cancelled.
+		if ctx.Err() == nil {
+			f.record(i, err)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", f.sources[i], err.Error()))
+			continue
+		}
+
+		// A source with nothing to say (ex. a realtime feed late at night)
+		// isn't failing, but a lower priority source might know more.
+		answered = true
+		if len(predictions) == 0 {
+			continue
+		}
+
+		// Like an ensemble, name the configured source that won, so that
+		// Source is the same whichever way sources are combined.
+		for j := range predictions {
+			predictions[j].Source = f.sources[i]
+		}
+		f.succeeded(i)
+		return predictions, nil
+	}
+
+	if answered {
+		return nil, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("All prediction sources are cooling down")
+	}
+	return nil, fmt.Errorf("All prediction sources failed: %s", strings.Join(errs, "; "))
+}
+
+// LastSource returns the name of the source that most recently returned
+// predictions.
+func (f *fallbackPredictor) LastSource() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastSource
+}
+
+func (f *fallbackPredictor) coolingDown(i int, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return now.Before(f.skipUntil[i])
+}
+
+// record updates the health of a source after it's queried.
+func (f *fallbackPredictor) record(i int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		f.failures[i] = 0
+		return
+	}
+
+	f.failures[i]++
+	if f.failures[i] >= f.failureThreshold {
+		f.skipUntil[i] = time.Now().Add(f.cooldown)
+		f.failures[i] = 0
+		fmt.Printf("%s: skipping %s for %s after %d failures\n", f.name, f.sources[i], f.cooldown, f.failureThreshold)
+	}
+}
+
+func (f *fallbackPredictor) succeeded(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lastSource != f.sources[i] {
+		fmt.Printf("%s: now using predictions from %s\n", f.name, f.sources[i])
+	}
+	f.lastSource = f.sources[i]
+}
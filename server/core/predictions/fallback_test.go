@@ -0,0 +1,35 @@
+package predictions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFallbackPredict(t *testing.T) {
+	failing := &stubPredictor{err: errors.New("The 511.org API responded with a non-200 status code: 500")}
+	empty := &stubPredictor{}
+	schedule := &stubPredictor{predictions: []Prediction{{Minutes: 4, Source: "GTFS schedule"}}}
+	f := newFallbackPredictor("fallback:muni", []string{"511-siri", "gtfs-rt", "schedule:muni"},
+		[]Predictor{failing, empty, schedule}, 2, time.Minute)
+
+	stop := Stop{Route: "N", Code: 15201}
+	for i := 0; i < 3; i++ {
+		predictions, err := f.Predict(context.Background(), &stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Predictions are labelled with the configured source that made
+		// them.
+		if len(predictions) != 1 || predictions[0].Source != "schedule:muni" {
+			t.Errorf("unexpected predictions %+v", predictions)
+		}
+	}
+	if source := f.LastSource(); source != "schedule:muni" {
+		t.Errorf("got last source %q, expected schedule:muni", source)
+	}
+	if !f.coolingDown(0, time.Now()) || f.coolingDown(1, time.Now()) {
+		t.Errorf("expected only the failing source to cool down")
+	}
+}
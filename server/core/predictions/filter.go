@@ -0,0 +1,43 @@
+package predictions
+
+import "strings"
+
+var (
+	// directionAbbreviations maps direction names to the abbreviated codes
+	// used by some feeds, ex. the 511.org SIRI API.
+	directionAbbreviations = map[string]string{
+		"inbound":  "IB",
+		"outbound": "OB",
+	}
+)
+
+// routeVariants maps a route code to the codes of other routes that should be
+// treated as the same route, ex. "N" -> ["N_OWL"] to include the overnight bus
+// that replaces the N-Judah late at night.
+type routeVariants map[string][]string
+
+// matches returns true if departures on the route with the given code should
+// be reported for the stop. A stop without a configured route matches every
+// route.
+func (v routeVariants) matches(stop *Stop, code string) bool {
+	if stop.Route == "" || strings.EqualFold(stop.Route, code) {
+		return true
+	}
+	for _, variant := range v[stop.Route] {
+		if strings.EqualFold(variant, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesDirection returns true if departures in the direction with the given
+// code should be reported for the stop. A stop without a configured direction
+// matches every direction.
+func matchesDirection(stop *Stop, code string) bool {
+	if stop.Direction == "" || strings.EqualFold(stop.Direction, code) {
+		return true
+	}
+	abbreviation, ok := directionAbbreviations[strings.ToLower(stop.Direction)]
+	return ok && strings.EqualFold(abbreviation, code)
+}
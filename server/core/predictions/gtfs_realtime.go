@@ -0,0 +1,172 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// gtfsRealtimeMaxAge is how long a fetched feed is reused. A feed covers
+	// every stop of an agency, so there's no reason to fetch it once per stop.
+	gtfsRealtimeMaxAge = 5 * time.Second
+)
+
+// gtfsRealtimePredictor predicts departures from a GTFS-Realtime TripUpdates
+// feed. The feed may be a URL or the path to a file on disk.
+type gtfsRealtimePredictor struct {
+	feed          string
+	client        *upstreamClient
+	routeVariants routeVariants
+
+	fetches   singleflight.Group
+	mu        sync.Mutex
+	fetchedAt time.Time
+	message   *gtfs.FeedMessage
+}
+
+var _ Predictor = &gtfsRealtimePredictor{}
+
+// newGTFSRealtimeSource builds a predictor for the GTFS-realtime feed in the
+// source's options.
+func newGTFSRealtimeSource(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	var o feedConfig
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	feed, err := c.feedLocation(o)
+	if err != nil {
+		return nil, err
+	}
+	if o.Key != "" {
+		c.consumes(o.Key)
+	}
+	return &gtfsRealtimePredictor{
+		feed:          feed,
+		client:        c.client(o.upstreamConfig),
+		routeVariants: c.routeVariants,
+	}, nil
+}
+
+func (g *gtfsRealtimePredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	message, err := g.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return g.parse(message, stop, clock(ctx)), nil
+}
+
+// fetch returns the current feed message, reusing a recently fetched one if
+// possible. Concurrent fetches of a stale feed share a single request, which
+// is made without holding g.mu. The request isn't bound to any one caller's
+// context, so that a caller giving up doesn't fail the others.
+func (g *gtfsRealtimePredictor) fetch(ctx context.Context) (*gtfs.FeedMessage, error) {
+	g.mu.Lock()
+	if g.message != nil && time.Since(g.fetchedAt) < gtfsRealtimeMaxAge {
+		message := g.message
+		g.mu.Unlock()
+		return message, nil
+	}
+	g.mu.Unlock()
+
+	results := g.fetches.DoChan(g.feed, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+		defer cancel()
+		b, err := readFeed(fetchCtx, g.client, g.feed)
+		if err != nil {
+			return nil, err
+		}
+
+		var message gtfs.FeedMessage
+		if err := proto.Unmarshal(b, &message); err != nil {
+			return nil, err
+		}
+		g.mu.Lock()
+		g.message = &message
+		g.fetchedAt = time.Now()
+		g.mu.Unlock()
+		return &message, nil
+	})
+	select {
+	case res := <-results:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*gtfs.FeedMessage), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// parse extracts the departures from the feed that stop at the stop. GTFS
+// stop IDs already distinguish between directions, so only the route is
+// filtered.
+func (g *gtfsRealtimePredictor) parse(message *gtfs.FeedMessage, stop *Stop, now time.Time) []Prediction {
+	stopID := stop.gtfsStopID()
+
+	var predictions []Prediction
+	for _, entity := range message.GetEntity() {
+		tripUpdate := entity.GetTripUpdate()
+		if entity.GetIsDeleted() || tripUpdate == nil {
+			continue
+		}
+		trip := tripUpdate.GetTrip()
+		if trip.GetScheduleRelationship() == gtfs.TripDescriptor_CANCELED {
+			continue
+		}
+		if !g.routeVariants.matches(stop, trip.GetRouteId()) {
+			continue
+		}
+		var recordedAt time.Time
+		if timestamp := tripUpdate.GetTimestamp(); timestamp != 0 {
+			recordedAt = time.Unix(int64(timestamp), 0)
+		} else if timestamp := message.GetHeader().GetTimestamp(); timestamp != 0 {
+			recordedAt = time.Unix(int64(timestamp), 0)
+		}
+
+		for _, update := range tripUpdate.GetStopTimeUpdate() {
+			if update.GetStopId() != stopID {
+				continue
+			}
+			if update.GetScheduleRelationship() != gtfs.TripUpdate_StopTimeUpdate_SCHEDULED {
+				continue
+			}
+
+			event := update.GetDeparture()
+			if event.GetTime() == 0 {
+				event = update.GetArrival()
+			}
+			if event.GetTime() == 0 {
+				continue
+			}
+			departsAt := time.Unix(event.GetTime(), 0)
+			if departsAt.Before(now) {
+				continue
+			}
+
+			prediction := Prediction{
+				CreatedAt:  now,
+				DepartsAt:  departsAt,
+				Minutes:    int(departsAt.Sub(now) / time.Minute),
+				Stop:       stop,
+				Source:     "GTFS-realtime",
+				RecordedAt: recordedAt,
+				RouteCode:  trip.GetRouteId(),
+				TripID:     trip.GetTripId(),
+				VehicleID:  tripUpdate.GetVehicle().GetId(),
+			}
+			// A missing direction isn't direction 0.
+			if trip.DirectionId != nil {
+				prediction.DirectionCode = strconv.Itoa(int(*trip.DirectionId))
+			}
+			predictions = append(predictions, prediction)
+		}
+	}
+	return predictions
+}
@@ -0,0 +1,170 @@
+package predictions
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGTFSRealtimePredict(t *testing.T) {
+	// The fixture's header timestamp.
+	now := time.Unix(1467305000, 0)
+	g := &gtfsRealtimePredictor{
+		feed:          "../../fixtures/trip_updates.pb",
+		routeVariants: routeVariants{"N": {"NX"}},
+	}
+
+	testCases := []struct {
+		name    string
+		stop    Stop
+		trips   []string
+		minutes []int
+	}{
+		// Trips use their departure time rather than their arrival time,
+		// unless they end at the stop. Skipped stops, cancelled trips and
+		// trips that already left are left out.
+		{"N", Stop{Route: "N", Code: 15201},
+			[]string{"11239834", "11239835", "11240451", "11240600"}, []int{2, 7, 14, 10}},
+		{"any route", Stop{Code: 15201},
+			[]string{"11239834", "11239835", "11240451", "11240600", "11310050"}, []int{2, 7, 14, 10, 4}},
+		{"28", Stop{Route: "28", Code: 15201}, []string{"11310050"}, []int{4}},
+		{"other route", Stop{Route: "7", Code: 15201}, nil, nil},
+		{"stop_id option", Stop{Route: "N", Code: 15201, Options: map[string]string{"stop_id": "15202"}},
+			[]string{"11239901"}, []int{3}},
+		{"other stop", Stop{Route: "N", Code: 16630}, nil, nil},
+	}
+
+	ctx := withClock(context.Background(), func() time.Time { return now })
+	for _, tc := range testCases {
+		stop := tc.stop
+		predictions, err := g.Predict(ctx, &stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var trips []string
+		var minutes []int
+		for _, p := range predictions {
+			trips = append(trips, p.TripID)
+			minutes = append(minutes, p.Minutes)
+			if p.Stop != &stop || p.Source != "GTFS-realtime" || !p.CreatedAt.Equal(now) {
+				t.Errorf("%s: unexpected prediction %+v", tc.name, p)
+			}
+		}
+		if !reflect.DeepEqual(trips, tc.trips) || !reflect.DeepEqual(minutes, tc.minutes) {
+			t.Errorf("%s: got trips %v minutes %v, expected trips %v minutes %v",
+				tc.name, trips, minutes, tc.trips, tc.minutes)
+		}
+	}
+
+	stop := Stop{Route: "N", Code: 15201}
+	predictions, err := g.Predict(ctx, &stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := predictions[0]
+	if !first.DepartsAt.Equal(time.Unix(1467305150, 0)) || first.VehicleID != "2064" ||
+		first.RouteCode != "N" || first.DirectionCode != "1" || !first.RecordedAt.Equal(time.Unix(1467304985, 0)) {
+		t.Errorf("unexpected prediction %+v", first)
+	}
+	if last := predictions[len(predictions)-1]; !last.DepartsAt.Equal(time.Unix(1467305600, 0)) {
+		t.Errorf("got %s for a trip that ends at the stop, expected its arrival time", last.DepartsAt)
+	}
+}
+
+func TestGTFSRealtimeDirection(t *testing.T) {
+	now := time.Unix(1467305000, 0)
+	update := func(tripID string, directionID *uint32) *gtfs.FeedEntity {
+		return &gtfs.FeedEntity{
+			Id: proto.String(tripID),
+			TripUpdate: &gtfs.TripUpdate{
+				Trip: &gtfs.TripDescriptor{TripId: proto.String(tripID), RouteId: proto.String("N"), DirectionId: directionID},
+				StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{{
+					StopId:    proto.String("15201"),
+					Departure: &gtfs.TripUpdate_StopTimeEvent{Time: proto.Int64(now.Unix() + 120)},
+				}},
+			},
+		}
+	}
+	message := &gtfs.FeedMessage{
+		Header: &gtfs.FeedHeader{GtfsRealtimeVersion: proto.String("2.0")},
+		Entity: []*gtfs.FeedEntity{
+			update("outbound", proto.Uint32(0)),
+			update("inbound", proto.Uint32(1)),
+			update("unknown", nil),
+		},
+	}
+
+	g := &gtfsRealtimePredictor{}
+	var directions []string
+	for _, p := range g.parse(message, &Stop{Route: "N", Code: 15201}, now) {
+		directions = append(directions, p.DirectionCode)
+	}
+	if expected := []string{"0", "1", ""}; !reflect.DeepEqual(directions, expected) {
+		t.Errorf("got directions %q, expected %q", directions, expected)
+	}
+}
+
+func TestGTFSRealtimeFetchOutlivesCaller(t *testing.T) {
+	b, err := ioutil.ReadFile("../../fixtures/trip_updates.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := make(chan struct{})
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		<-gate
+		rw.Write(b)
+	}))
+	defer server.Close()
+	defer close(gate)
+
+	g := &gtfsRealtimePredictor{feed: server.URL, client: newUpstreamClient(upstreamConfig{})}
+	stop := Stop{Route: "N", Code: 15201}
+
+	// The caller that started the fetch gives up, but the fetch carries on.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := g.Predict(ctx, &stop)
+		errs <- err
+	}()
+	waitFor(t, "the feed to be requested", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests == 1
+	})
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, expected the cancelled caller to give up", err)
+	}
+	gate <- struct{}{}
+	waitFor(t, "the fetch to finish", func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.message != nil
+	})
+
+	now := time.Unix(1467305000, 0)
+	predictions, err := g.Predict(withClock(context.Background(), func() time.Time { return now }), &stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(predictions) == 0 || requests != 1 {
+		t.Errorf("got %d predictions from %d requests, expected the fetched feed to be reused", len(predictions), requests)
+	}
+}
@@ -0,0 +1,471 @@
+package predictions
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// scheduleHorizon is how far into the future scheduled departures are
+	// reported.
+	scheduleHorizon = 2 * time.Hour
+
+	gtfsDateLayout = "20060102"
+)
+
+type gtfsRoute struct {
+	shortName string
+	longName  string
+}
+
+type gtfsTrip struct {
+	routeID     string
+	serviceID   string
+	directionID string
+	headsign    string
+}
+
+type gtfsStopTime struct {
+	tripID string
+	// departure is the number of seconds after the start of the service day.
+	// It may exceed 24 hours for trips that run past midnight.
+	departure int
+}
+
+type gtfsService struct {
+	weekdays  [7]bool
+	startDate string
+	endDate   string
+}
+
+// gtfsSchedulePredictor predicts departures from a GTFS static feed. It's
+// useful as a fallback when realtime data is unavailable, but it only knows
+// when trains are supposed to depart.
+type gtfsSchedulePredictor struct {
+	routeVariants routeVariants
+	location      *time.Location
+	// stopIDs maps stop codes to stop IDs, for feeds where they differ.
+	stopIDs   map[string]string
+	routes    map[string]gtfsRoute
+	trips     map[string]gtfsTrip
+	stopTimes map[string][]gtfsStopTime
+	services  map[string]gtfsService
+	// exceptions maps service IDs to dates to whether service was added
+	// (true) or removed (false) on that date.
+	exceptions map[string]map[string]bool
+}
+
+var _ Predictor = &gtfsSchedulePredictor{}
+
+// newGTFSSchedulePredictor loads the GTFS static feed at the given URL or
+// path. Only stop times for the given stop IDs or stop codes are kept in
+// memory.
+func newGTFSSchedulePredictor(ctx context.Context, client *upstreamClient, feed string, stopIDs map[string]bool, routeVariants routeVariants) (*gtfsSchedulePredictor, error) {
+	b, err := readFeed(ctx, client, feed)
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gtfsSchedulePredictor{
+		routeVariants: routeVariants,
+		location:      time.Local,
+		stopIDs:       make(map[string]string),
+		routes:        make(map[string]gtfsRoute),
+		trips:         make(map[string]gtfsTrip),
+		stopTimes:     make(map[string][]gtfsStopTime),
+		services:      make(map[string]gtfsService),
+		exceptions:    make(map[string]map[string]bool),
+	}
+	if err := g.load(r, stopIDs); err != nil {
+		return nil, fmt.Errorf("Error loading GTFS feed %s: %s", feed, err.Error())
+	}
+	return g, nil
+}
+
+// newGTFSScheduleSource builds a predictor for the GTFS static feed in the
+// source's options. Only the stops in stops.json are loaded.
+func newGTFSScheduleSource(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	var o feedConfig
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	feed, err := c.feedLocation(o)
+	if err != nil {
+		return nil, err
+	}
+	stopIDs := make(map[string]bool)
+	for _, s := range c.stops {
+		stopIDs[s.gtfsStopID()] = true
+	}
+	return newGTFSSchedulePredictor(c.ctx, c.client(o.upstreamConfig), feed, stopIDs, c.routeVariants)
+}
+
+func (g *gtfsSchedulePredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	return g.predict(stop, clock(ctx)), nil
+}
+
+func (g *gtfsSchedulePredictor) predict(stop *Stop, now time.Time) []Prediction {
+	now = now.In(g.location)
+	stopID := stop.gtfsStopID()
+	if id, ok := g.stopIDs[stopID]; ok && stop.option("stop_id") == "" {
+		stopID = id
+	}
+	stopTimes := g.stopTimes[stopID]
+
+	var predictions []Prediction
+	// Trips from yesterday's service day may still be running after midnight.
+	for _, offset := range []int{-1, 0} {
+		day := now.AddDate(0, 0, offset)
+		start := serviceDayStart(day)
+		date := day.Format(gtfsDateLayout)
+
+		for _, stopTime := range stopTimes {
+			departsAt := start.Add(time.Duration(stopTime.departure) * time.Second)
+			if departsAt.Before(now) || departsAt.After(now.Add(scheduleHorizon)) {
+				continue
+			}
+			trip := g.trips[stopTime.tripID]
+			if !g.active(trip.serviceID, day.Weekday(), date) {
+				continue
+			}
+			route := g.routes[trip.routeID]
+			if !g.routeVariants.matches(stop, route.shortName) && !g.routeVariants.matches(stop, trip.routeID) {
+				continue
+			}
+
+			predictions = append(predictions, Prediction{
+				CreatedAt:     now,
+				DepartsAt:     departsAt,
+				Minutes:       int(departsAt.Sub(now) / time.Minute),
+				Stop:          stop,
+				Source:        "GTFS schedule",
+				TripID:        stopTime.tripID,
+				RouteCode:     route.shortName,
+				RouteName:     route.longName,
+				DirectionCode: trip.directionID,
+				DirectionName: trip.headsign,
+			})
+		}
+	}
+	return predictions
+}
+
+// active returns true if the service runs on the given date.
+func (g *gtfsSchedulePredictor) active(serviceID string, weekday time.Weekday, date string) bool {
+	if added, ok := g.exceptions[serviceID][date]; ok {
+		return added
+	}
+	service, ok := g.services[serviceID]
+	if !ok {
+		return false
+	}
+	return service.weekdays[weekday] && date >= service.startDate && date <= service.endDate
+}
+
+// holidays returns the dates, formatted as 2006-01-02, on which a weekend
+// service runs on a weekday and no weekday service runs.
+func (g *gtfsSchedulePredictor) holidays() []string {
+	seen := make(map[string]bool)
+	var holidays []string
+	for serviceID, dates := range g.exceptions {
+		if _, weekend := g.serviceDays(serviceID); !weekend {
+			continue
+		}
+		for date, added := range dates {
+			if !added || seen[date] {
+				continue
+			}
+			t, err := time.Parse(gtfsDateLayout, date)
+			if err != nil || t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+				continue
+			}
+			if g.weekdayServiceRuns(t.Weekday(), date) {
+				continue
+			}
+			seen[date] = true
+			holidays = append(holidays, t.Format(scheduleDateLayout))
+		}
+	}
+	sort.Strings(holidays)
+	return holidays
+}
+
+// serviceDays returns whether the service runs on weekdays or on weekends.
+// Services in calendar.txt are classified by their days of the week. Feeds
+// may list every date in calendar_dates.txt instead, so other services are
+// classified by the days they're mostly added on.
+func (g *gtfsSchedulePredictor) serviceDays(serviceID string) (weekday, weekend bool) {
+	if service, ok := g.services[serviceID]; ok {
+		weekend = service.weekdays[time.Saturday] || service.weekdays[time.Sunday]
+		weekday = !weekend && (service.weekdays[time.Monday] || service.weekdays[time.Friday])
+		return weekday, weekend
+	}
+	var weekdays, weekends int
+	for date, added := range g.exceptions[serviceID] {
+		t, err := time.Parse(gtfsDateLayout, date)
+		if !added || err != nil {
+			continue
+		}
+		if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+			weekends++
+		} else {
+			weekdays++
+		}
+	}
+	return weekdays > weekends, weekends > weekdays
+}
+
+// weekdayServiceRuns returns true if any weekday service runs on the date.
+func (g *gtfsSchedulePredictor) weekdayServiceRuns(day time.Weekday, date string) bool {
+	for serviceID := range g.services {
+		if weekday, _ := g.serviceDays(serviceID); weekday && g.active(serviceID, day, date) {
+			return true
+		}
+	}
+	for serviceID := range g.exceptions {
+		if _, ok := g.services[serviceID]; ok {
+			continue
+		}
+		if weekday, _ := g.serviceDays(serviceID); weekday && g.active(serviceID, day, date) {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceDayStart returns the time that GTFS stop times on the given day are
+// relative to. It's defined as noon minus 12 hours so that times are still
+// correct on days with daylight saving time transitions.
+func serviceDayStart(day time.Time) time.Time {
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, day.Location())
+	return noon.Add(-12 * time.Hour)
+}
+
+func (g *gtfsSchedulePredictor) load(r *zip.Reader, stopIDs map[string]bool) error {
+	files := make(map[string]*zip.File)
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+
+	if f, ok := files["agency.txt"]; ok {
+		err := readCSV(f, func(row map[string]string) error {
+			if row["agency_timezone"] == "" {
+				return nil
+			}
+			location, err := time.LoadLocation(row["agency_timezone"])
+			if err != nil {
+				return err
+			}
+			g.location = location
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if f, ok := files["routes.txt"]; ok {
+		err := readCSV(f, func(row map[string]string) error {
+			g.routes[row["route_id"]] = gtfsRoute{
+				shortName: row["route_short_name"],
+				longName:  row["route_long_name"],
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{"stops.txt", "trips.txt", "stop_times.txt"} {
+		if _, ok := files[name]; !ok {
+			return fmt.Errorf("missing %s", name)
+		}
+	}
+
+	err := readCSV(files["stops.txt"], func(row map[string]string) error {
+		if code := row["stop_code"]; code != "" {
+			g.stopIDs[code] = row["stop_id"]
+			if stopIDs != nil && stopIDs[code] {
+				stopIDs[row["stop_id"]] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = readCSV(files["trips.txt"], func(row map[string]string) error {
+		g.trips[row["trip_id"]] = gtfsTrip{
+			routeID:     row["route_id"],
+			serviceID:   row["service_id"],
+			directionID: row["direction_id"],
+			headsign:    row["trip_headsign"],
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = readCSV(files["stop_times.txt"], func(row map[string]string) error {
+		stopID := row["stop_id"]
+		if stopIDs != nil && !stopIDs[stopID] {
+			return nil
+		}
+		departure := row["departure_time"]
+		if departure == "" {
+			departure = row["arrival_time"]
+		}
+		if departure == "" {
+			// Untimed stops have their times interpolated by consumers. We
+			// don't bother.
+			return nil
+		}
+		seconds, err := parseGTFSTime(departure)
+		if err != nil {
+			return err
+		}
+		g.stopTimes[stopID] = append(g.stopTimes[stopID], gtfsStopTime{
+			tripID:    row["trip_id"],
+			departure: seconds,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, stopTimes := range g.stopTimes {
+		sort.Sort(byGTFSDeparture(stopTimes))
+	}
+
+	if f, ok := files["calendar.txt"]; ok {
+		weekdays := []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+		err := readCSV(f, func(row map[string]string) error {
+			var service gtfsService
+			for i, day := range weekdays {
+				service.weekdays[i] = row[day] == "1"
+			}
+			service.startDate = row["start_date"]
+			service.endDate = row["end_date"]
+			g.services[row["service_id"]] = service
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if f, ok := files["calendar_dates.txt"]; ok {
+		err := readCSV(f, func(row map[string]string) error {
+			serviceID := row["service_id"]
+			if g.exceptions[serviceID] == nil {
+				g.exceptions[serviceID] = make(map[string]bool)
+			}
+			g.exceptions[serviceID][row["date"]] = row["exception_type"] == "1"
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// byGTFSDeparture sorts stop times by their departure time.
+type byGTFSDeparture []gtfsStopTime
+
+func (s byGTFSDeparture) Len() int           { return len(s) }
+func (s byGTFSDeparture) Less(i, j int) bool { return s[i].departure < s[j].departure }
+func (s byGTFSDeparture) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// parseGTFSTime parses a GTFS time of the form HH:MM:SS into the number of
+// seconds since the start of the service day. Hours may be 24 or greater.
+func parseGTFSTime(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid GTFS time %q", s)
+	}
+	var seconds int
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid GTFS time %q", s)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+// readCSV calls fn with every row of the CSV file, keyed by the column names
+// in its header.
+func readCSV(f *zip.File, fn func(row map[string]string) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	r := csv.NewReader(rc)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.Name, err.Error())
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	row := make(map[string]string, len(header))
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", f.Name, err.Error())
+		}
+		for i, column := range header {
+			row[column] = ""
+			if i < len(record) {
+				row[column] = strings.TrimSpace(record[i])
+			}
+		}
+		if err := fn(row); err != nil {
+			return fmt.Errorf("%s: %s", f.Name, err.Error())
+		}
+	}
+}
+
+// readFeed reads the feed at the given URL or path.
+func readFeed(ctx context.Context, client *upstreamClient, feed string) ([]byte, error) {
+	if !strings.HasPrefix(feed, "http://") && !strings.HasPrefix(feed, "https://") {
+		return ioutil.ReadFile(feed)
+	}
+
+	resp, err := client.do(ctx, http.MethodGet, feed)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with a non-200 status code: %v", feed, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,112 @@
+package predictions
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseGTFSTime(t *testing.T) {
+	testCases := []struct {
+		s       string
+		seconds int
+		ok      bool
+	}{
+		{"08:05:00", 8*3600 + 5*60, true},
+		{" 8:05:30", 8*3600 + 5*60 + 30, true},
+		// Trips that run past midnight belong to the previous service day.
+		{"25:10:00", 25*3600 + 10*60, true},
+		{"08:05", 0, false},
+		{"08:xx:00", 0, false},
+	}
+	for _, tc := range testCases {
+		seconds, err := parseGTFSTime(tc.s)
+		if (err == nil) != tc.ok || seconds != tc.seconds {
+			t.Errorf("parseGTFSTime(%q) = %d, %v, expected %d", tc.s, seconds, err, tc.seconds)
+		}
+	}
+}
+
+func TestGTFSScheduleActive(t *testing.T) {
+	weekdays := [7]bool{false, true, true, true, true, true, false}
+	g := &gtfsSchedulePredictor{
+		services: map[string]gtfsService{
+			"weekday": {weekdays: weekdays, startDate: "20160101", endDate: "20161231"},
+		},
+		exceptions: map[string]map[string]bool{
+			"weekday": {"20160704": false, "20160709": true},
+			"special": {"20160705": true},
+		},
+	}
+
+	testCases := []struct {
+		serviceID string
+		date      string
+		active    bool
+	}{
+		{"weekday", "20160630", true},
+		{"weekday", "20160702", false},
+		{"weekday", "20170102", false},
+		// Exceptions override the calendar.
+		{"weekday", "20160704", false},
+		{"weekday", "20160709", true},
+		// Services may be defined by their exceptions alone.
+		{"special", "20160705", true},
+		{"special", "20160706", false},
+		{"unknown", "20160630", false},
+	}
+	for _, tc := range testCases {
+		day, err := time.Parse(gtfsDateLayout, tc.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active := g.active(tc.serviceID, day.Weekday(), tc.date); active != tc.active {
+			t.Errorf("%s on %s: got %t, expected %t", tc.serviceID, tc.date, active, tc.active)
+		}
+	}
+}
+
+func TestGTFSScheduleHolidays(t *testing.T) {
+	// July 4th and Labor Day run the Sunday schedule. July 5th adds an extra
+	// Saturday service alongside the weekday one.
+	calendar := &gtfsSchedulePredictor{
+		services: map[string]gtfsService{
+			"weekday":  {weekdays: [7]bool{false, true, true, true, true, true, false}, startDate: "20160101", endDate: "20161231"},
+			"saturday": {weekdays: [7]bool{false, false, false, false, false, false, true}, startDate: "20160101", endDate: "20161231"},
+			"sunday":   {weekdays: [7]bool{true, false, false, false, false, false, false}, startDate: "20160101", endDate: "20161231"},
+		},
+		exceptions: map[string]map[string]bool{
+			"weekday":  {"20160704": false, "20160905": false},
+			"saturday": {"20160705": true},
+			"sunday":   {"20160704": true, "20160905": true},
+		},
+	}
+	expected := []string{"2016-07-04", "2016-09-05"}
+	if holidays := calendar.holidays(); !reflect.DeepEqual(holidays, expected) {
+		t.Errorf("got %v from calendar.txt, expected %v", holidays, expected)
+	}
+
+	// The same schedule, listed date by date in calendar_dates.txt.
+	calendarDates := &gtfsSchedulePredictor{
+		services: map[string]gtfsService{},
+		exceptions: map[string]map[string]bool{
+			"weekday":  make(map[string]bool),
+			"saturday": {"20160705": true},
+			"sunday":   make(map[string]bool),
+		},
+	}
+	for day := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC); day.Year() == 2016; day = day.AddDate(0, 0, 1) {
+		date := day.Format(gtfsDateLayout)
+		switch {
+		case date == "20160704" || date == "20160905" || day.Weekday() == time.Sunday:
+			calendarDates.exceptions["sunday"][date] = true
+		case day.Weekday() == time.Saturday:
+			calendarDates.exceptions["saturday"][date] = true
+		default:
+			calendarDates.exceptions["weekday"][date] = true
+		}
+	}
+	if holidays := calendarDates.holidays(); !reflect.DeepEqual(holidays, expected) {
+		t.Errorf("got %v from calendar_dates.txt, expected %v", holidays, expected)
+	}
+}
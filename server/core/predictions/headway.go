@@ -0,0 +1,227 @@
+package predictions
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBunchedWithin = 2 * time.Minute
+	defaultBunchedRatio  = 0.25
+	defaultGapRatio      = 2
+	// minHeadwaySamples is how many gaps between departures are needed to
+	// estimate the expected headway.
+	minHeadwaySamples = 5
+	// maxHeadwaySamples bounds how many observed gaps are kept per hour.
+	maxHeadwaySamples = 50
+	// maxObservedHeadway is the longest gap between observed departures
+	// that's taken as a headway rather than a break in service.
+	maxObservedHeadway = time.Hour
+
+	headwayBasisSchedule = "schedule"
+	headwayBasisObserved = "observed"
+)
+
+// headwayConfig configures how departures are flagged. Departures closer
+// together than BunchedRatio of the expected headway are bunched, as are
+// departures within BunchedWithin if the expected headway isn't known. A gap
+// of more than GapRatio times the expected headway is abnormal.
+type headwayConfig struct {
+	BunchedWithin duration `json:"bunched_within"`
+	BunchedRatio  float64  `json:"bunched_ratio"`
+	GapRatio      float64  `json:"gap_ratio"`
+}
+
+func (c *headwayConfig) setDefaults() {
+	if c.BunchedWithin <= 0 {
+		c.BunchedWithin = duration(defaultBunchedWithin)
+	}
+	if c.BunchedRatio <= 0 {
+		c.BunchedRatio = defaultBunchedRatio
+	}
+	if c.GapRatio <= 0 {
+		c.GapRatio = defaultGapRatio
+	}
+}
+
+// Headway describes how often departures are expected from a stop, and
+// whether the predicted departures are bunched or have abnormal gaps.
+type Headway struct {
+	// ExpectedMinutes is the scheduled or typical time between departures,
+	// if it's known. Basis is where it came from: "schedule" or "observed".
+	ExpectedMinutes float64 `json:"expected_minutes,omitempty"`
+	Basis           string  `json:"basis,omitempty"`
+	// Bunched is true if any of the predicted departures are bunched.
+	Bunched bool `json:"bunched"`
+	// Gap is true if there's an abnormal gap between any of the predicted
+	// departures.
+	Gap bool `json:"gap"`
+}
+
+// headwayTracker learns the typical headway at each stop, by hour of the
+// day, from the departures seen leaving it.
+type headwayTracker struct {
+	mu           sync.Mutex
+	lastDeparted map[string]time.Time
+	// gaps maps stops to hours of the day to the gaps between departures.
+	gaps map[string]map[int][]time.Duration
+}
+
+func newHeadwayTracker() *headwayTracker {
+	return &headwayTracker{
+		lastDeparted: make(map[string]time.Time),
+		gaps:         make(map[string]map[int][]time.Duration),
+	}
+}
+
+// observe records the gaps between the departures that left the stop.
+func (h *headwayTracker) observe(stop string, departed []departure) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sort.Sort(byActual(departed))
+	for _, d := range departed {
+		last := h.lastDeparted[stop]
+		h.lastDeparted[stop] = d.actual
+		gap := d.actual.Sub(last)
+		if last.IsZero() || gap <= 0 || gap > maxObservedHeadway {
+			continue
+		}
+		if h.gaps[stop] == nil {
+			h.gaps[stop] = make(map[int][]time.Duration)
+		}
+		hour := d.actual.Hour()
+		gaps := append(h.gaps[stop][hour], gap)
+		if len(gaps) > maxHeadwaySamples {
+			gaps = gaps[len(gaps)-maxHeadwaySamples:]
+		}
+		h.gaps[stop][hour] = gaps
+	}
+}
+
+// typical returns the median gap between departures from the stop during the
+// hour of the given time, if enough have been seen.
+func (h *headwayTracker) typical(stop string, now time.Time) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	gaps := h.gaps[stop][now.Hour()]
+	if len(gaps) < minHeadwaySamples {
+		return 0, false
+	}
+	return medianDuration(gaps), true
+}
+
+// scheduledHeadway returns the median gap between the stop's scheduled
+// departures over the next couple of hours, if one of the GTFS schedules
+// covers it.
+func scheduledHeadway(schedules []*gtfsSchedulePredictor, stop *Stop, now time.Time) (time.Duration, bool) {
+	for _, g := range schedules {
+		predictions := g.predict(stop, now)
+		if len(predictions) <= minHeadwaySamples {
+			continue
+		}
+		sort.Sort(byDeparture(predictions))
+		gaps := make([]time.Duration, 0, len(predictions)-1)
+		for i := 1; i < len(predictions); i++ {
+			gaps = append(gaps, predictions[i].DepartsAt.Sub(predictions[i-1].DepartsAt))
+		}
+		return medianDuration(gaps), true
+	}
+	return 0, false
+}
+
+// stopSchedules returns the GTFS schedules that the stop's source uses, in
+// order of their names. Other schedules may be of other agencies, whose stop
+// codes can overlap.
+func (m *Module) stopSchedules(stop *Stop) []*gtfsSchedulePredictor {
+	var names []string
+	for name := range m.schedules {
+		if m.usesSource(stop.source(), name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	schedules := make([]*gtfsSchedulePredictor, len(names))
+	for i, name := range names {
+		schedules[i] = m.schedules[name]
+	}
+	return schedules
+}
+
+// expectedHeadway returns the stop's scheduled headway from its source's
+// schedules, or failing that its typical observed headway. The caller must
+// not hold m.mu.
+func (m *Module) expectedHeadway(key string, now time.Time) Headway {
+	stop := m.stops[key]
+	if headway, ok := scheduledHeadway(m.stopSchedules(&stop), &stop, now); ok {
+		return Headway{ExpectedMinutes: headway.Minutes(), Basis: headwayBasisSchedule}
+	}
+	if headway, ok := m.headways.typical(key, now); ok {
+		return Headway{ExpectedMinutes: headway.Minutes(), Basis: headwayBasisObserved}
+	}
+	return Headway{}
+}
+
+// flagHeadways flags the sorted predictions that are bunched together or
+// that follow an abnormal gap, and records the gaps between them. Each
+// prediction is only compared with the previous one of the same route, so
+// that variants of a route, ex. the N and the N OWL, aren't taken for
+// bunched.
+func flagHeadways(predictions []Prediction, headway *Headway, c headwayConfig) {
+	expected := time.Duration(headway.ExpectedMinutes * float64(time.Minute))
+	bunchedWithin := time.Duration(c.BunchedWithin)
+	if expected > 0 {
+		bunchedWithin = time.Duration(float64(expected) * c.BunchedRatio)
+	}
+
+	last := make(map[string]*Prediction)
+	for i := range predictions {
+		p := &predictions[i]
+		route := strings.ToUpper(p.RouteCode)
+		prev, ok := last[route]
+		last[route] = p
+		if !ok {
+			continue
+		}
+		gap := p.DepartsAt.Sub(prev.DepartsAt)
+		p.HeadwayMinutes = int(gap / time.Minute)
+		if gap < bunchedWithin {
+			prev.Bunched, p.Bunched = true, true
+			headway.Bunched = true
+		}
+		if expected > 0 && float64(gap) > float64(expected)*c.GapRatio {
+			p.GapBefore = true
+			headway.Gap = true
+		}
+	}
+}
+
+// Headway returns the expected headway of the stop and whether its current
+// predictions are bunched or have gaps.
+func (m *Module) Headway(stop string) Headway {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latestHeadways[stop]
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Sort(byDuration(sorted))
+	return sorted[len(sorted)/2]
+}
+
+// byActual sorts departures by when they actually left.
+type byActual []departure
+
+func (d byActual) Len() int           { return len(d) }
+func (d byActual) Less(i, j int) bool { return d[i].actual.Before(d[j].actual) }
+func (d byActual) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// byDuration sorts durations in ascending order.
+type byDuration []time.Duration
+
+func (d byDuration) Len() int           { return len(d) }
+func (d byDuration) Less(i, j int) bool { return d[i] < d[j] }
+func (d byDuration) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
@@ -0,0 +1,167 @@
+package predictions
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFlagHeadways(t *testing.T) {
+	now := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	type departure struct {
+		route   string
+		minutes int
+	}
+
+	testCases := []struct {
+		name       string
+		expected   float64
+		departures []departure
+		// headways, bunched and gaps are the flags of each departure.
+		headways []int
+		bunched  []bool
+		gaps     []bool
+	}{
+		{
+			name:       "even",
+			expected:   10,
+			departures: []departure{{"N", 2}, {"N", 12}, {"N", 22}},
+			headways:   []int{0, 10, 10},
+			bunched:    []bool{false, false, false},
+			gaps:       []bool{false, false, false},
+		},
+		{
+			name:       "bunched",
+			expected:   10,
+			departures: []departure{{"N", 2}, {"N", 4}, {"N", 14}},
+			headways:   []int{0, 2, 10},
+			bunched:    []bool{true, true, false},
+			gaps:       []bool{false, false, false},
+		},
+		{
+			name:       "gap",
+			expected:   10,
+			departures: []departure{{"N", 2}, {"N", 25}, {"N", 35}},
+			headways:   []int{0, 23, 10},
+			bunched:    []bool{false, false, false},
+			gaps:       []bool{false, true, false},
+		},
+		{
+			// Without an expected headway, departures within 2 minutes
+			// are bunched and gaps aren't flagged.
+			name:       "unknown headway",
+			departures: []departure{{"N", 2}, {"N", 3}, {"N", 40}},
+			headways:   []int{0, 1, 37},
+			bunched:    []bool{true, true, false},
+			gaps:       []bool{false, false, false},
+		},
+		{
+			name:       "route variants",
+			expected:   10,
+			departures: []departure{{"N", 2}, {"N_OWL", 3}, {"N", 12}, {"n_owl", 30}},
+			headways:   []int{0, 0, 10, 27},
+			bunched:    []bool{false, false, false, false},
+			gaps:       []bool{false, false, false, true},
+		},
+	}
+	for _, tc := range testCases {
+		var predictions []Prediction
+		for _, d := range tc.departures {
+			predictions = append(predictions, Prediction{
+				RouteCode: d.route,
+				Minutes:   d.minutes,
+				DepartsAt: now.Add(time.Duration(d.minutes) * time.Minute),
+			})
+		}
+		headway := Headway{ExpectedMinutes: tc.expected}
+		c := headwayConfig{}
+		c.setDefaults()
+		flagHeadways(predictions, &headway, c)
+
+		var headways []int
+		var bunched, gaps []bool
+		for _, p := range predictions {
+			headways = append(headways, p.HeadwayMinutes)
+			bunched = append(bunched, p.Bunched)
+			gaps = append(gaps, p.GapBefore)
+		}
+		if !reflect.DeepEqual(headways, tc.headways) {
+			t.Errorf("%s: got headways %v, expected %v", tc.name, headways, tc.headways)
+		}
+		if !reflect.DeepEqual(bunched, tc.bunched) {
+			t.Errorf("%s: got bunched %v, expected %v", tc.name, bunched, tc.bunched)
+		}
+		if !reflect.DeepEqual(gaps, tc.gaps) {
+			t.Errorf("%s: got gaps %v, expected %v", tc.name, gaps, tc.gaps)
+		}
+		var anyBunched, gap bool
+		for i := range tc.bunched {
+			anyBunched = anyBunched || tc.bunched[i]
+			gap = gap || tc.gaps[i]
+		}
+		if headway.Bunched != anyBunched {
+			t.Errorf("%s: got headway bunched %t, expected %t", tc.name, headway.Bunched, anyBunched)
+		}
+		if headway.Gap != gap {
+			t.Errorf("%s: got headway gap %t, expected %t", tc.name, headway.Gap, gap)
+		}
+	}
+}
+
+// scheduleEvery returns a schedule with departures of the N from the stop
+// every interval, every day.
+func scheduleEvery(code int, interval time.Duration) *gtfsSchedulePredictor {
+	g := &gtfsSchedulePredictor{
+		location: time.UTC,
+		routes:   map[string]gtfsRoute{"N": {shortName: "N"}},
+		trips:    make(map[string]gtfsTrip),
+		services: map[string]gtfsService{
+			"daily": {weekdays: [7]bool{true, true, true, true, true, true, true}, startDate: "20160101", endDate: "20161231"},
+		},
+		stopTimes: make(map[string][]gtfsStopTime),
+	}
+	stopID := strconv.Itoa(code)
+	for departure := 0; departure < 24*60*60; departure += int(interval / time.Second) {
+		tripID := strconv.Itoa(departure)
+		g.trips[tripID] = gtfsTrip{routeID: "N", serviceID: "daily"}
+		g.stopTimes[stopID] = append(g.stopTimes[stopID], gtfsStopTime{tripID: tripID, departure: departure})
+	}
+	return g
+}
+
+func TestExpectedHeadwayUsesOwnSchedule(t *testing.T) {
+	// Both agencies have a stop 15201, on different schedules.
+	m := &Module{
+		stops: map[string]Stop{
+			"muni":     {Route: "N", Code: 15201, Source: "fallback:muni"},
+			"ac":       {Route: "N", Code: 15201, Source: "schedule:ac"},
+			"realtime": {Route: "N", Code: 15201, Source: "511.org"},
+		},
+		schedules: map[string]*gtfsSchedulePredictor{
+			"schedule:muni": scheduleEvery(15201, 10*time.Minute),
+			"schedule:ac":   scheduleEvery(15201, 20*time.Minute),
+		},
+		sourceDependencies: map[string][]string{
+			"fallback:muni": {"511.org", "schedule:muni"},
+		},
+		headways: newHeadwayTracker(),
+	}
+	now := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		stop    string
+		headway Headway
+	}{
+		{"muni", Headway{ExpectedMinutes: 10, Basis: headwayBasisSchedule}},
+		{"ac", Headway{ExpectedMinutes: 20, Basis: headwayBasisSchedule}},
+		// Its source doesn't use a schedule, and no departures have been
+		// seen yet.
+		{"realtime", Headway{}},
+	}
+	for _, tc := range testCases {
+		if headway := m.expectedHeadway(tc.stop, now); headway != tc.headway {
+			t.Errorf("%s: got %+v, expected %+v", tc.stop, headway, tc.headway)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package predictions
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	defaultCompactInterval  = 24 * time.Hour
+	// pruneHistoryInterval is how often entries past the retention period
+	// are deleted.
+	pruneHistoryInterval = time.Hour
+	// compactTxMaxSize bounds the size of each transaction while compacting.
+	compactTxMaxSize = 64 << 20
+)
+
+var (
+	// ErrHistoryDisabled is returned when the history isn't enabled in
+	// config.json.
+	ErrHistoryDisabled = errors.New("Prediction history isn't enabled in config.json")
+
+	// minHistoryTime and maxHistoryTime bound the times that history keys
+	// can represent.
+	minHistoryTime = time.Unix(0, 0)
+	maxHistoryTime = time.Unix(0, math.MaxInt64)
+
+	// renameFile replaces the database with its compacted copy. It's a
+	// variable so that tests can make it fail.
+	renameFile = os.Rename
+)
+
+// historyConfig configures the prediction history. If Path is set, every
+// refresh's predictions are appended to a bbolt database there and kept for
+// Retention. The database file is compacted every CompactInterval to reclaim
+// the space of deleted entries.
+type historyConfig struct {
+	Path            string   `json:"path"`
+	Retention       duration `json:"retention"`
+	CompactInterval duration `json:"compact_interval"`
+}
+
+// HistoryEntry is the predictions for a stop from a single refresh.
+type HistoryEntry struct {
+	RefreshedAt time.Time `json:"refreshed_at"`
+	// Idle is true if the stop was idle, so the refresh was at the idle
+	// cadence.
+	Idle        bool         `json:"idle,omitempty"`
+	Predictions []Prediction `json:"predictions"`
+}
+
+// historyStore is an append-only store of past predictions. Each stop has a
+// bucket of entries keyed by the time of the refresh.
+type historyStore struct {
+	path            string
+	retention       time.Duration
+	compactInterval time.Duration
+
+	// mu guards db, which is replaced while compacting.
+	mu sync.RWMutex
+	db *bolt.DB
+}
+
+func newHistoryStore(c historyConfig) (*historyStore, error) {
+	h := &historyStore{
+		path:            c.Path,
+		retention:       time.Duration(c.Retention),
+		compactInterval: time.Duration(c.CompactInterval),
+	}
+	if h.retention <= 0 {
+		h.retention = defaultHistoryRetention
+	}
+	if h.compactInterval <= 0 {
+		h.compactInterval = defaultCompactInterval
+	}
+	var err error
+	if h.db, err = bolt.Open(h.path, 0644, &bolt.Options{Timeout: time.Second}); err != nil {
+		return nil, fmt.Errorf("Unable to open history %s: %s", h.path, err.Error())
+	}
+	return h, nil
+}
+
+// historyKey returns the key of an entry, which sorts by time. Times outside
+// the range that keys can represent are clamped to it.
+func historyKey(t time.Time) []byte {
+	if t.Before(minHistoryTime) {
+		t = minHistoryTime
+	} else if t.After(maxHistoryTime) {
+		t = maxHistoryTime
+	}
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+// append adds the predictions from a refresh of the stop.
+func (h *historyStore) append(stop string, entry HistoryEntry) error {
+	// The stop is the same for every prediction in the bucket, so there's
+	// no need to store it over and over.
+	predictions := entry.Predictions
+	entry.Predictions = make([]Prediction, len(predictions))
+	for i, p := range predictions {
+		p.Stop = nil
+		entry.Predictions[i] = p
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.db == nil {
+		return h.notOpen()
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(stop))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(historyKey(entry.RefreshedAt), b)
+	})
+}
+
+// query returns the stop's entries from refreshes in [from, to), oldest first.
+func (h *historyStore) query(stop string, from, to time.Time) ([]HistoryEntry, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.db == nil {
+		return nil, h.notOpen()
+	}
+
+	var entries []HistoryEntry
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(stop))
+		if bucket == nil {
+			return nil
+		}
+		end := historyKey(to)
+		c := bucket.Cursor()
+		for k, v := c.Seek(historyKey(from)); k != nil && string(k) < string(end); k, v = c.Next() {
+			var entry HistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// prune deletes entries older than the retention period.
+func (h *historyStore) prune(now time.Time) error {
+	cutoff := historyKey(now.Add(-h.retention))
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.db == nil {
+		return h.notOpen()
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			// Deleting while iterating with a cursor can skip keys, so
+			// find them all first.
+			var expired [][]byte
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.Next() {
+				expired = append(expired, k)
+			}
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// compact rewrites the database file without the space left behind by
+// deleted entries. bbolt never shrinks its file on its own. Writers wait
+// until the compacted copy has replaced the database, and if it can't, the
+// original is reopened.
+func (h *historyStore) compact() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db == nil {
+		return h.notOpen()
+	}
+
+	tmpPath := h.path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0644, nil)
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, h.db, compactTxMaxSize); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := h.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	renameErr := renameFile(tmpPath, h.path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+	}
+	if h.db, err = bolt.Open(h.path, 0644, &bolt.Options{Timeout: time.Second}); err != nil {
+		h.db = nil
+		return fmt.Errorf("Unable to reopen history %s: %s", h.path, err.Error())
+	}
+	return renameErr
+}
+
+// notOpen returns the error for using the history after it failed to reopen.
+func (h *historyStore) notOpen() error {
+	return fmt.Errorf("History %s isn't open", h.path)
+}
+
+// maintain runs in its own goroutine, pruning and compacting the history
+// until the context is done.
+func (h *historyStore) maintain(ctx context.Context) {
+	pruneTicker := time.NewTicker(pruneHistoryInterval)
+	defer pruneTicker.Stop()
+	compactTicker := time.NewTicker(h.compactInterval)
+	defer compactTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-pruneTicker.C:
+			if err := h.prune(now); err != nil {
+				fmt.Fprintf(os.Stderr, "Error pruning history: %s\n", err.Error())
+			}
+		case <-compactTicker.C:
+			if err := h.compact(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error compacting history: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+func (h *historyStore) close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db == nil {
+		return nil
+	}
+	return h.db.Close()
+}
+
+// History returns the stop's predictions from every refresh between from and
+// to, oldest first. It returns an error if the history isn't enabled.
+func (m *Module) History(stop string, from, to time.Time) ([]HistoryEntry, error) {
+	if m.history == nil {
+		return nil, ErrHistoryDisabled
+	}
+	return m.history.query(stop, from, to)
+}
@@ -0,0 +1,143 @@
+package predictions
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestHistory(t *testing.T) (*historyStore, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newHistoryStore(historyConfig{Path: filepath.Join(dir, "history.db"), Retention: duration(time.Hour)})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return h, func() {
+		h.close()
+		os.RemoveAll(dir)
+	}
+}
+
+func appendRefreshes(t *testing.T, h *historyStore, stop string, start time.Time, n int) {
+	for i := 0; i < n; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		predictions := []Prediction{{CreatedAt: at, DepartsAt: at.Add(5 * time.Minute), Minutes: 5, Stop: &Stop{Code: 15201}}}
+		if err := h.append(stop, HistoryEntry{RefreshedAt: at, Predictions: predictions}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHistoryQueryAndPrune(t *testing.T) {
+	h, cleanup := newTestHistory(t)
+	defer cleanup()
+
+	start := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	appendRefreshes(t, h, "judah", start, 90)
+	appendRefreshes(t, h, "church", start, 10)
+
+	entries, err := h.query("judah", start.Add(10*time.Minute), start.Add(20*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 10 || !entries[0].RefreshedAt.Equal(start.Add(10*time.Minute)) {
+		t.Fatalf("got %d entries from %s, expected 10", len(entries), entries[0].RefreshedAt)
+	}
+	if p := entries[0].Predictions[0]; p.Minutes != 5 || p.Stop != nil {
+		t.Errorf("unexpected prediction %+v", p)
+	}
+
+	// Keep the last hour.
+	if err := h.prune(start.Add(90 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = h.query("judah", start, start.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 60 || !entries[0].RefreshedAt.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("got %d entries after pruning, expected 60", len(entries))
+	}
+	if entries, _ := h.query("church", start, start.Add(2*time.Hour)); len(entries) != 0 {
+		t.Errorf("got %d expired entries", len(entries))
+	}
+}
+
+func TestHistoryQueryExtremeRange(t *testing.T) {
+	h, cleanup := newTestHistory(t)
+	defer cleanup()
+
+	start := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	appendRefreshes(t, h, "judah", start, 10)
+
+	// Times outside what keys can represent would wrap around if they
+	// weren't clamped.
+	testCases := []struct {
+		from, to time.Time
+		entries  int
+	}{
+		{time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC), 10},
+		{time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), start.Add(5 * time.Minute), 5},
+		{start.Add(5 * time.Minute), time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), 5},
+		{time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+	}
+	for _, tc := range testCases {
+		entries, err := h.query("judah", tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != tc.entries {
+			t.Errorf("%s to %s: got %d entries, expected %d", tc.from, tc.to, len(entries), tc.entries)
+		}
+	}
+}
+
+func TestHistoryCompact(t *testing.T) {
+	h, cleanup := newTestHistory(t)
+	defer cleanup()
+
+	start := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	appendRefreshes(t, h, "judah", start, 90)
+	if err := h.compact(); err != nil {
+		t.Fatal(err)
+	}
+	appendRefreshes(t, h, "judah", start.Add(90*time.Minute), 1)
+	entries, err := h.query("judah", start, start.Add(2*time.Hour))
+	if err != nil || len(entries) != 91 {
+		t.Fatalf("got %d entries and error %v after compacting, expected 91", len(entries), err)
+	}
+	if _, err := os.Stat(h.path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expected the compacted copy to be moved, got %v", err)
+	}
+}
+
+func TestHistoryCompactRenameFailure(t *testing.T) {
+	h, cleanup := newTestHistory(t)
+	defer cleanup()
+	defer func() { renameFile = os.Rename }()
+	renameErr := errors.New("rename failed")
+	renameFile = func(from, to string) error { return renameErr }
+
+	start := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	appendRefreshes(t, h, "judah", start, 10)
+	if err := h.compact(); err != renameErr {
+		t.Fatalf("got %v, expected the rename's error", err)
+	}
+
+	// The original database is reopened, and still takes writes.
+	appendRefreshes(t, h, "judah", start.Add(10*time.Minute), 1)
+	entries, err := h.query("judah", start, start.Add(time.Hour))
+	if err != nil || len(entries) != 11 {
+		t.Fatalf("got %d entries and error %v after a failed compaction, expected 11", len(entries), err)
+	}
+	if _, err := os.Stat(h.path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expected the compacted copy to be removed, got %v", err)
+	}
+}
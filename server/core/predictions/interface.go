@@ -1,20 +1,52 @@
 package predictions
 
-import "time"
+import (
+	"context"
+	"strconv"
+	"time"
+)
 
 // Predictor defines an interface for things that can predict muni arrival
-// times.
+// times. Predictors should give up once the context is done.
 type Predictor interface {
-	Predict(stop *Stop) ([]Prediction, error)
+	Predict(ctx context.Context, stop *Stop) ([]Prediction, error)
 }
 
 // Prediction encapsulates information about a predicted muni departure from
 // a stop.
 type Prediction struct {
 	CreatedAt time.Time `json:"created_at"`
+	DepartsAt time.Time `json:"departs_at"`
 	Minutes   int       `json:"minutes"`
 	Stop      *Stop     `json:"stop"`
 	Source    string    `json:"source"`
+	// RecordedAt is when the source's data behind the prediction was
+	// recorded upstream, ex. a SIRI visit's RecordedAtTime or a
+	// GTFS-realtime trip update's timestamp. It's zero if the source doesn't
+	// say.
+	RecordedAt time.Time `json:"recorded_at"`
+	// Sources lists every source that agreed on the departure, if the
+	// prediction was merged from several sources.
+	Sources       []string `json:"sources,omitempty"`
+	TripID        string   `json:"trip_id"`
+	VehicleID     string   `json:"vehicle_id"`
+	RouteCode     string   `json:"route_code"`
+	RouteName     string   `json:"route_name"`
+	DirectionCode string   `json:"direction_code"`
+	DirectionName string   `json:"direction_name"`
+	// Corrected is true if DepartsAt and Minutes were adjusted by the
+	// source's learned error. The source's own values are kept in
+	// RawDepartsAt and RawMinutes.
+	Corrected    bool       `json:"corrected,omitempty"`
+	RawDepartsAt *time.Time `json:"raw_departs_at,omitempty"`
+	RawMinutes   *int       `json:"raw_minutes,omitempty"`
+	// HeadwayMinutes is the time since the previous departure of the same
+	// route. Bunched is true if the departure is unusually close to the one
+	// before or after it, and GapBefore if it's unusually far from the one
+	// before it.
+	HeadwayMinutes int  `json:"headway_minutes,omitempty"`
+	Bunched        bool `json:"bunched,omitempty"`
+	GapBefore      bool `json:"gap_before,omitempty"`
 }
 
 // Stop represents a public-transit stop and the information required to query
@@ -25,4 +57,43 @@ type Stop struct {
 	Direction string `json:"direction"`
 	Name      string `json:"name"`
 	Code      int    `json:"code"`
+	// Source is the name of the source to query for the stop, ex.
+	// "gtfs-rt:muni". It defaults to the 511.org API.
+	Source string `json:"source,omitempty"`
+	// Options are source-specific options for the stop, ex. "stop_id" for
+	// GTFS sources.
+	Options map[string]string `json:"options,omitempty"`
+	// Priority weighs how much of a shared request budget the stop gets. It
+	// defaults to 1, and is boosted during the stop's PeakHours, ex. "7-10".
+	Priority  int      `json:"priority,omitempty"`
+	PeakHours []string `json:"peak_hours,omitempty"`
+	// Schedule overrides the refresh schedule in config.json for the stop.
+	Schedule []ScheduleRule `json:"schedule,omitempty"`
+}
+
+func (s *Stop) priority() int {
+	if s.Priority > 0 {
+		return s.Priority
+	}
+	return 1
+}
+
+func (s *Stop) option(key string) string {
+	return s.Options[key]
+}
+
+// gtfsStopID returns the GTFS stop_id of the stop, which defaults to the
+// stop code.
+func (s *Stop) gtfsStopID() string {
+	if id := s.option("stop_id"); id != "" {
+		return id
+	}
+	return strconv.Itoa(s.Code)
+}
+
+func (s *Stop) source() string {
+	if s.Source != "" {
+		return s.Source
+	}
+	return source511
 }
@@ -1,7 +1,7 @@
 package predictions
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -15,12 +15,28 @@ import (
 
 const (
 	checkInterval = time.Second
+	// refreshWorkers bounds how many stops are refreshed at once.
+	refreshWorkers = 4
+	// maxDemandRefreshes bounds how many idle stops are refreshed at once on
+	// request, apart from the workers.
+	maxDemandRefreshes = refreshWorkers
+	// refreshTimeout bounds how long refreshing a single stop may take.
+	refreshTimeout = 15 * time.Second
+
+	source511          = "511.org"
+	sourceGTFSRealtime = "gtfs-rt"
+	sourceGTFSSchedule = "schedule"
+	sourceSIRI         = "511-siri"
+	sourceFallback     = "fallback"
+	sourceEnsemble     = "ensemble"
+	sourceReplay       = "replay"
+	sourceCorrected    = "corrected"
 )
 
 var (
-	// defaultPredicate configures how frequently to query the prediction source for
+	// defaultInterval configures how frequently to query the prediction source for
 	// new predictions.
-	defaultPredicate = composite(
+	defaultInterval = composite(
 		atNight(interval(time.Minute)),       // only once per minute at night
 		onWeekends(interval(30*time.Second)), // only twice per minute on the weekends
 		inMornings(interval(10*time.Second)), // every 10 seconds on weekdays in the morning
@@ -32,39 +48,206 @@ var (
 type Module struct {
 	Config *config.Module
 
-	mu                   sync.Mutex
-	keys                 map[string]string
-	stops                map[string]Stop
-	latestPredictions    map[string][]Prediction
-	lastUpdatedTimestamp time.Time
-	ticker               *time.Ticker
-	predictor            Predictor
+	mu                sync.Mutex
+	config            predictionsConfig
+	keys              map[string]string
+	stops             map[string]Stop
+	latestPredictions map[string][]Prediction
+	latestHeadways    map[string]Headway
+	stopStatuses      map[string]StopStatus
+	lastRequested     map[string]time.Time
+	// refreshing maps stops that are being refreshed to channels that are
+	// closed once they're done.
+	refreshing map[string]chan struct{}
+	// demandRefreshes counts the refreshes of idle stops that requests have
+	// started and that haven't finished.
+	demandRefreshes int
+	// refreshQueue holds the stops waiting for a refresh worker. Stops are
+	// marked as being refreshed before they're queued, so each is queued at
+	// most once and the queue never fills.
+	refreshQueue chan string
+	intervals    map[string]updateInterval
+	location     *time.Location
+	holidays     map[string]bool
+	breakers     map[string]*breaker
+	quotas       *quotaManager
+	// recorder records upstream requests, if recording is enabled.
+	recorder *recorder
+	// catalog lists 511.org agencies, routes and stops, if there's an access
+	// token for it.
+	catalog *catalog
+	// history stores past predictions, if it's enabled.
+	history  *historyStore
+	accuracy *accuracyTracker
+	headways *headwayTracker
+	// schedules maps the names of GTFS schedule sources to them. They give
+	// the scheduled headways of the stops that use them.
+	schedules map[string]*gtfsSchedulePredictor
+	// sourceRequests maps each source to the number of requests a prediction
+	// from it makes with each access token.
+	sourceRequests map[string]map[string]int
+	// sourceDependencies maps each source to the sources it combines.
+	sourceDependencies map[string][]string
+	ticker             *time.Ticker
+	predictors         map[string]Predictor
+	// ctx is cancelled when the module stops, which cancels any requests in
+	// flight.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // Init implements the service.Module interface and installs appropriate lifecycle hooks.
 func (m *Module) Init(c *service.Config) {
 	c.Setup = m.setup
 	c.Start = m.start
+	c.Stop = m.stop
+	c.AddCommand(&service.Command{
+		Keyword:    "search-stops",
+		Run:        m.searchStopsCommand,
+		ShortUsage: "search-stops <query>",
+		Usage:      "Searches 511.org for stops by name, route or direction, ex. \"judah 22nd\", and prints their codes for stops.json.",
+	})
 }
 
 func (m *Module) setup() error {
 	m.keys = make(map[string]string)
 	m.stops = make(map[string]Stop)
 	m.latestPredictions = make(map[string][]Prediction)
+	m.stopStatuses = make(map[string]StopStatus)
+	m.lastRequested = make(map[string]time.Time)
+	m.refreshing = make(map[string]chan struct{})
+	m.latestHeadways = make(map[string]Headway)
+	m.accuracy = newAccuracyTracker()
+	m.headways = newHeadwayTracker()
+	m.ctx, m.cancel = context.WithCancel(context.Background())
 
+	if err := m.Config.Load("config.json", &m.config); err != nil {
+		return err
+	}
 	if err := m.Config.Load("stops.json", &m.stops); err != nil {
 		return err
 	}
 	if err := m.Config.Load("keys.json", &m.keys); err != nil {
 		return err
 	}
+	m.config.Demand.setDefaults()
+	m.config.Headways.setDefaults()
+	m.refreshQueue = make(chan string, len(m.stops))
 
-	if _, ok := m.keys["511.org"]; !ok {
-		return errors.New("No 511.org access token provided in keys.json")
+	schedule := defaultInterval
+	if len(m.config.Schedule) > 0 {
+		var err error
+		if schedule, err = parseSchedule(m.config.Schedule, defaultInterval); err != nil {
+			return fmt.Errorf("Invalid schedule in config.json: %s", err.Error())
+		}
 	}
 
-	m.predictor = &defaultPredictor{accessToken: m.keys["511.org"]}
-	return nil
+	m.intervals = make(map[string]updateInterval)
+	m.breakers = make(map[string]*breaker)
+	for k, s := range m.stops {
+		m.intervals[k] = schedule
+		if len(s.Schedule) > 0 {
+			stopInterval, err := parseSchedule(s.Schedule, schedule)
+			if err != nil {
+				return fmt.Errorf("Stop %q has an invalid schedule: %s", k, err.Error())
+			}
+			m.intervals[k] = stopInterval
+		}
+		m.breakers[k] = newBreaker(k, m.config.Breaker)
+	}
+
+	if m.config.Recording.Dir != "" {
+		var err error
+		if m.recorder, err = newRecorder(m.config.Recording); err != nil {
+			return err
+		}
+	}
+
+	m.setupCatalog()
+
+	if m.config.History.Path != "" {
+		var err error
+		if m.history, err = newHistoryStore(m.config.History); err != nil {
+			return err
+		}
+	}
+
+	m.predictors = make(map[string]Predictor)
+	m.sourceRequests = make(map[string]map[string]int)
+	m.sourceDependencies = make(map[string][]string)
+	stopRequests := make(map[string]map[string]int)
+	for k, s := range m.stops {
+		if _, err := m.source(s.source(), nil); err != nil {
+			return fmt.Errorf("Stop %q: %s", k, err.Error())
+		}
+		stopRequests[k] = m.sourceRequests[s.source()]
+	}
+
+	m.schedules = make(map[string]*gtfsSchedulePredictor)
+	for name, predictor := range m.predictors {
+		if schedule, ok := predictor.(*gtfsSchedulePredictor); ok {
+			m.schedules[name] = schedule
+		}
+	}
+
+	if err := m.setupCalendar(); err != nil {
+		return err
+	}
+
+	var err error
+	m.quotas, err = newQuotaManager(m.config.Quotas, m.stops, stopRequests)
+	return err
+}
+
+// source returns the predictor with the given name, building it from its
+// options in config.json if necessary. building holds the names of the
+// sources currently being built, to catch sources that depend on themselves.
+func (m *Module) source(name string, building []string) (Predictor, error) {
+	if predictor, ok := m.predictors[name]; ok {
+		return predictor, nil
+	}
+	for _, b := range building {
+		if b == name {
+			return nil, fmt.Errorf("Source %q depends on itself", name)
+		}
+	}
+
+	factory, ok := sourceFactories[sourceKind(name)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown source %q", name)
+	}
+	building = append(building[:len(building):len(building)], name)
+	c := &sourceContext{
+		ctx:           m.ctx,
+		name:          name,
+		keys:          m.keys,
+		routeVariants: m.config.RouteVariants,
+		stops:         m.stops,
+		upstream:      m.config.Upstream,
+		recorder:      m.recorder,
+		options:       m.config.Sources,
+		history:       m.history,
+		location:      func() *time.Location { return m.location },
+		requests:      make(map[string]int),
+	}
+	c.source = func(dependency string) (Predictor, error) {
+		predictor, err := m.source(dependency, building)
+		if err != nil {
+			return nil, err
+		}
+		for key, n := range m.sourceRequests[dependency] {
+			c.requests[key] += n
+		}
+		m.sourceDependencies[name] = append(m.sourceDependencies[name], dependency)
+		return predictor, nil
+	}
+	predictor, err := factory(c, m.config.Sources[name])
+	if err != nil {
+		return nil, fmt.Errorf("Error configuring source %q: %s", name, err.Error())
+	}
+	m.predictors[name] = predictor
+	m.sourceRequests[name] = c.requests
+	return predictor, nil
 }
 
 func (m *Module) start() {
@@ -72,19 +255,82 @@ func (m *Module) start() {
 	for k, s := range m.stops {
 		fmt.Printf(" - %s (%s %s)\n", k, s.Name, s.Direction)
 	}
-	if err := m.refreshPredictions(); err != nil {
-		panic(err)
+	// Stops count as requested when the server starts, so that they aren't
+	// idle while displays reconnect.
+	now := time.Now()
+	m.mu.Lock()
+	for k := range m.stops {
+		m.lastRequested[k] = now
 	}
+	m.mu.Unlock()
+	// Learning may read weeks of history, so it doesn't hold up the first
+	// refresh. Sources predict without their corrections until it's done.
+	go m.learnFromHistory()
+	m.startRefreshWorkers()
+	m.queueRefreshes(now)
 	m.ticker = time.NewTicker(checkInterval)
 	go m.updatePeriodically()
+	if m.history != nil {
+		go m.history.maintain(m.ctx)
+	}
 }
 
-// Stop returns data about the stop with the given key.
-func (m *Module) Stop(stopKey string) Stop {
-	return m.stops[stopKey]
+// learnFromHistory lets sources that learn from history, ex. "corrected"
+// sources, learn from the history of the stops that use them.
+func (m *Module) learnFromHistory() {
+	for name, predictor := range m.predictors {
+		learner, ok := predictor.(historyLearner)
+		if !ok {
+			continue
+		}
+		stops := make(map[string]Stop)
+		for k, s := range m.stops {
+			if m.usesSource(s.source(), name) {
+				stops[k] = s
+			}
+		}
+		if err := learner.learn(m.ctx, stops); err != nil {
+			fmt.Fprintf(os.Stderr, "Error learning source %q from history: %s\n", name, err.Error())
+		}
+	}
+}
+
+// usesSource returns true if the source is the named source or combines it,
+// directly or through other sources.
+func (m *Module) usesSource(source, name string) bool {
+	if source == name {
+		return true
+	}
+	for _, dependency := range m.sourceDependencies[source] {
+		if m.usesSource(dependency, name) {
+			return true
+		}
+	}
+	return false
 }
 
-// Current returns all the current route predictions for the given stop.
+// stop cancels any refreshes in flight and stops refreshing predictions.
+func (m *Module) stop() {
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+	m.cancel()
+	if m.history != nil {
+		if err := m.history.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error closing history: %s\n", err.Error())
+		}
+	}
+}
+
+// Stop returns data about the stop with the given key, and whether the stop
+// exists.
+func (m *Module) Stop(stopKey string) (Stop, bool) {
+	stop, ok := m.stops[stopKey]
+	return stop, ok
+}
+
+// Current returns all the current route predictions for the given stop, ordered
+// by departure time.
 func (m *Module) Current(stop string) []Prediction {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -92,55 +338,171 @@ func (m *Module) Current(stop string) []Prediction {
 	return m.latestPredictions[stop]
 }
 
-func (m *Module) LastUpdated() time.Time {
+// startRefreshWorkers starts the workers that refresh queued stops, several
+// at a time, until the module stops. A stop that hangs until it times out
+// only holds up its own worker.
+func (m *Module) startRefreshWorkers() {
+	for i := 0; i < refreshWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case <-m.ctx.Done():
+					return
+				case key := <-m.refreshQueue:
+					m.refreshStop(key)
+				}
+			}
+		}()
+	}
+}
+
+// queueRefreshes queues the stops that are due to be refreshed, without
+// waiting for them. Stops that are still being refreshed aren't due.
+func (m *Module) queueRefreshes(now time.Time) {
+	for _, key := range m.dueStops(now) {
+		m.refreshQueue <- key
+	}
+}
+
+// refreshStop refreshes a stop that beginRefresh marked as being refreshed,
+// and records the outcome.
+func (m *Module) refreshStop(key string) {
+	startedAt := time.Now()
+	err := m.refreshPredictionsForStop(key)
+	m.recordRefresh(key, startedAt, err)
+	m.endRefresh(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error refreshing %s: %s\n", key, err.Error())
+		return
+	}
+
+	// Print the current predictions
+	var minutes []string
+	for _, prediction := range m.Current(key) {
+		minutes = append(minutes, strconv.Itoa(prediction.Minutes))
+	}
+	fmt.Printf("%s: %s\n", key, strings.Join(minutes, ", "))
+}
+
+// dueStops returns the stops that should be refreshed now: their schedules
+// call for it, or the idle cadence if no client is watching them, their
+// request budgets allow it and their circuit breakers aren't open.
+func (m *Module) dueStops(now time.Time) []string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.lastUpdatedTimestamp
+
+	now = m.localTime(now)
+	var keys []string
+	for key := range m.stops {
+		if _, ok := m.refreshing[key]; ok {
+			continue
+		}
+		if m.idle(key, now) {
+			if !m.idleDue(key, now) {
+				continue
+			}
+		} else if due := due(m.intervals[key], now, m.stopStatuses[key].LastAttempt, m); due == nil || !*due {
+			continue
+		}
+		if m.allowRefresh(key, now) {
+			m.beginRefresh(key)
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
-func (m *Module) refreshPredictions() error {
+// allowRefresh returns true if the stop's request budget and circuit breaker
+// allow refreshing it now, and if so counts the refresh against its budget.
+// The caller must hold m.mu.
+func (m *Module) allowRefresh(key string, now time.Time) bool {
+	if now.Sub(m.stopStatuses[key].LastAttempt) < m.quotas.interval(key, now) {
+		return false
+	}
+	if !m.breakers[key].allow(now) {
+		return false
+	}
+	m.quotas.record(key, now)
+	return true
+}
+
+// refreshInterval returns how often the stop is being refreshed: its
+// schedule's interval, unless its request budget calls for longer. It also
+// returns whether the stop is idle, in which case it's refreshed at the idle
+// cadence instead.
+func (m *Module) refreshInterval(key string, now time.Time) (time.Duration, bool) {
 	m.mu.Lock()
-	m.lastUpdatedTimestamp = time.Now()
-	m.mu.Unlock()
+	defer m.mu.Unlock()
 
-	for k, s := range m.stops {
-		if err := m.refreshPredictionsForStop(k, &s); err != nil {
-			return err
-		}
+	now = m.localTime(now)
+	if m.idle(key, now) {
+		return time.Duration(m.config.Demand.IdleInterval), true
 	}
-	return nil
+	var interval time.Duration
+	if scheduled := m.intervals[key](now, m); scheduled != nil {
+		interval = *scheduled
+	}
+	if budget := m.quotas.interval(key, now); budget > interval {
+		interval = budget
+	}
+	return interval, false
 }
 
-func (m *Module) refreshPredictionsForStop(key string, stop *Stop) error {
-	predictions, err := m.predictor.Predict(stop)
+func (m *Module) refreshPredictionsForStop(key string) error {
+	stop := m.stops[key]
+	interval, idle := m.refreshInterval(key, time.Now())
+
+	// Idle refreshes are too far apart to tell when departures left, so
+	// they aren't learned from.
+	ctx := withRefreshInterval(m.ctx, interval)
+	if idle {
+		ctx = withIdleRefresh(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	predictions, err := m.predictors[stop.source()].Predict(ctx, &stop)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Timed out after %s", refreshTimeout)
+	}
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
 	if err != nil {
 		return err
 	}
+	sortPredictions(predictions)
+
+	now := time.Now()
+	var departed []departure
+	if !idle {
+		departed = m.accuracy.observe(key, m.localTime(now), interval, predictions)
+	}
+	m.headways.observe(key, departed)
+	headway := m.expectedHeadway(key, m.localTime(now))
+	flagHeadways(predictions, &headway, m.config.Headways)
 
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	m.latestPredictions[key] = predictions
+	m.latestHeadways[key] = headway
+	m.mu.Unlock()
+
+	if m.history != nil {
+		if err := m.history.append(key, HistoryEntry{RefreshedAt: now, Idle: idle, Predictions: predictions}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving history of %s: %s\n", key, err.Error())
+		}
+	}
 	return nil
 }
 
 // updatePeriodically runs in its own goroutine and periodically fetches new departure
 // predictions.
 func (m *Module) updatePeriodically() {
-	for _ = range m.ticker.C {
-		shouldUpdate := defaultPredicate(time.Now(), m.LastUpdated(), m)
-		if shouldUpdate != nil && *shouldUpdate {
-			if err := m.refreshPredictions(); err != nil {
-				fmt.Fprintf(os.Stderr, "Error refreshing: %s\n", err.Error())
-			}
-
-			// Print the current predictions
-			for s := range m.stops {
-				var minutes []string
-				for _, prediction := range m.Current(s) {
-					minutes = append(minutes, strconv.Itoa(prediction.Minutes))
-				}
-				fmt.Printf("%s: %s\n", s, strings.Join(minutes, ", "))
-			}
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-m.ticker.C:
 		}
+		m.queueRefreshes(time.Now())
 	}
 }
@@ -0,0 +1,154 @@
+package predictions
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockingPredictor hangs until it's released or its context is done.
+type blockingPredictor struct {
+	release chan struct{}
+}
+
+func (b *blockingPredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	select {
+	case <-b.release:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// newTestModule returns a module for the stops as setup would build it, with
+// the given predictors in place of configured sources. Stops are due on every
+// check and aren't idle, and the module is stopped when the test ends.
+func newTestModule(t *testing.T, stops map[string]Stop, predictors map[string]Predictor) *Module {
+	m := &Module{
+		stops:             stops,
+		predictors:        predictors,
+		latestPredictions: make(map[string][]Prediction),
+		latestHeadways:    make(map[string]Headway),
+		stopStatuses:      make(map[string]StopStatus),
+		lastRequested:     make(map[string]time.Time),
+		refreshing:        make(map[string]chan struct{}),
+		refreshQueue:      make(chan string, len(stops)),
+		intervals:         make(map[string]updateInterval),
+		breakers:          make(map[string]*breaker),
+		holidays:          make(map[string]bool),
+		location:          time.UTC,
+		accuracy:          newAccuracyTracker(),
+		headways:          newHeadwayTracker(),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	t.Cleanup(m.cancel)
+	m.config.Demand.setDefaults()
+	m.config.Headways.setDefaults()
+
+	now := time.Now()
+	for k := range stops {
+		m.intervals[k] = interval(0)
+		m.breakers[k] = newBreaker(k, breakerConfig{})
+		m.lastRequested[k] = now
+	}
+	var err error
+	if m.quotas, err = newQuotaManager(nil, stops, nil); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// waitFor fails the test if cond doesn't become true within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRefreshWorkersDontWaitForSlowStops(t *testing.T) {
+	slow := &blockingPredictor{release: make(chan struct{})}
+	defer close(slow.release)
+	fast := &stubPredictor{predictions: []Prediction{{Minutes: 4, RouteCode: "N"}}}
+	m := newTestModule(t, map[string]Stop{
+		"slow": {Route: "N", Code: 16630, Source: "slow"},
+		"fast": {Route: "N", Code: 15201, Source: "fast"},
+	}, map[string]Predictor{"slow": slow, "fast": fast})
+	m.startRefreshWorkers()
+
+	// The fast stop is refreshed on every check while the slow one hangs,
+	// and the slow one isn't queued again while it's in flight.
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		m.queueRefreshes(time.Now())
+		waitFor(t, "the fast stop to refresh", func() bool {
+			status := m.Status("fast")
+			return status.LastSuccess.After(last) && len(m.Current("fast")) == 1
+		})
+		last = m.Status("fast").LastSuccess
+	}
+	m.mu.Lock()
+	_, slowRefreshing := m.refreshing["slow"]
+	queued := len(m.refreshQueue)
+	m.mu.Unlock()
+	if !slowRefreshing || queued != 0 {
+		t.Errorf("expected only the slow stop to be in flight, got %d queued", queued)
+	}
+	if status := m.Status("slow"); !status.LastAttempt.IsZero() {
+		t.Errorf("expected the slow stop to still be refreshing, got %+v", status)
+	}
+}
+
+// deadlinePredictor records the deadline of the context it's called with.
+type deadlinePredictor struct {
+	deadline time.Time
+	ok       bool
+}
+
+func (d *deadlinePredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	d.deadline, d.ok = ctx.Deadline()
+	return nil, nil
+}
+
+func TestRefreshDeadline(t *testing.T) {
+	source := &deadlinePredictor{}
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "511.org"},
+	}, map[string]Predictor{"511.org": source})
+
+	start := time.Now()
+	if err := m.refreshPredictionsForStop("judah"); err != nil {
+		t.Fatal(err)
+	}
+	if !source.ok || source.deadline.Before(start) || source.deadline.After(time.Now().Add(refreshTimeout)) {
+		t.Errorf("got deadline %s (%t), expected one within %s", source.deadline, source.ok, refreshTimeout)
+	}
+}
+
+func TestStopCancelsRefreshes(t *testing.T) {
+	slow := &blockingPredictor{release: make(chan struct{})}
+	defer close(slow.release)
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "slow"},
+	}, map[string]Predictor{"slow": slow})
+
+	m.mu.Lock()
+	done := m.beginRefresh("judah")
+	m.mu.Unlock()
+	go m.refreshStop("judah")
+	m.stop()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the refresh to be cancelled")
+	}
+	// The cancelled refresh says nothing about the source.
+	status := m.Status("judah")
+	if status.LastError != context.Canceled.Error() || status.Breaker != breakerClosed || status.RetryAt != nil {
+		t.Errorf("expected a cancelled refresh that doesn't trip the breaker, got %+v", status)
+	}
+}
@@ -2,12 +2,14 @@ package predictions
 
 import "time"
 
-type updatePredicate func(now time.Time, lastUpdated time.Time, m *Module) *bool
+// updateInterval returns how long to wait between refreshes at the given time,
+// or nil if it doesn't apply then.
+type updateInterval func(now time.Time, m *Module) *time.Duration
 
-func composite(preds ...updatePredicate) updatePredicate {
-	return func(now time.Time, lastUpdated time.Time, m *Module) *bool {
-		for _, p := range preds {
-			if res := p(now, lastUpdated, m); res != nil {
+func composite(intervals ...updateInterval) updateInterval {
+	return func(now time.Time, m *Module) *time.Duration {
+		for _, i := range intervals {
+			if res := i(now, m); res != nil {
 				return res
 			}
 		}
@@ -15,41 +17,70 @@ func composite(preds ...updatePredicate) updatePredicate {
 	}
 }
 
-func interval(duration time.Duration) updatePredicate {
-	return func(now time.Time, lastUpdated time.Time, m *Module) *bool {
-		res := now.After(lastUpdated.Add(duration))
-		return &res
+func interval(duration time.Duration) updateInterval {
+	return func(now time.Time, m *Module) *time.Duration {
+		return &duration
 	}
 }
 
-func onWeekends(inner updatePredicate) updatePredicate {
-	return func(now time.Time, lastUpdated time.Time, m *Module) *bool {
-		weekday := now.Weekday()
+func onWeekends(inner updateInterval) updateInterval {
+	return func(now time.Time, m *Module) *time.Duration {
+		weekday := m.weekday(now)
 		if weekday != time.Saturday && weekday != time.Sunday {
 			return nil
 		}
 
-		return inner(now, lastUpdated, m)
+		return inner(now, m)
 	}
 }
 
-func inMornings(inner updatePredicate) updatePredicate {
-	return func(now time.Time, lastUpdated time.Time, m *Module) *bool {
-		h := now.Hour()
-		if h >= 8 && h < 11 {
-			return inner(now, lastUpdated, m)
+func inMornings(inner updateInterval) updateInterval {
+	return inHours(8, 11, inner)
+}
+
+func atNight(inner updateInterval) updateInterval {
+	return inHours(23, 7, inner)
+}
+
+// inHours applies the inner interval from the start hour until the end hour,
+// wrapping around midnight if end is before start.
+func inHours(start, end int, inner updateInterval) updateInterval {
+	return func(now time.Time, m *Module) *time.Duration {
+		if inHourRange(now.Hour(), start, end) {
+			return inner(now, m)
 		}
+		return nil
+	}
+}
 
+func onDays(days map[time.Weekday]bool, inner updateInterval) updateInterval {
+	return func(now time.Time, m *Module) *time.Duration {
+		if days[m.weekday(now)] {
+			return inner(now, m)
+		}
 		return nil
 	}
 }
 
-func atNight(inner updatePredicate) updatePredicate {
-	return func(now time.Time, lastUpdated time.Time, m *Module) *bool {
-		h := now.Hour()
-		if h == 23 || h < 7 {
-			return inner(now, lastUpdated, m)
+// betweenDates applies the inner interval from the start date through the end
+// date, inclusive. Dates are formatted as 2006-01-02.
+func betweenDates(start, end string, inner updateInterval) updateInterval {
+	return func(now time.Time, m *Module) *time.Duration {
+		date := now.Format(scheduleDateLayout)
+		if date >= start && date <= end {
+			return inner(now, m)
 		}
 		return nil
 	}
 }
+
+// due returns whether a refresh is due at the given time, if the last one was
+// at lastUpdated, or nil if the interval doesn't apply then.
+func due(i updateInterval, now time.Time, lastUpdated time.Time, m *Module) *bool {
+	interval := i(now, m)
+	if interval == nil {
+		return nil
+	}
+	res := now.After(lastUpdated.Add(*interval))
+	return &res
+}
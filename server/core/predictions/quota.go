@@ -0,0 +1,188 @@
+package predictions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	quotaWindow = time.Hour
+	// peakMultiplier is how much more of the budget a stop gets during its
+	// peak hours.
+	peakMultiplier = 4
+	// catalogBudgetShare is how much of an access token's budget the stop
+	// catalog may use, so that searches leave the rest to the stops.
+	catalogBudgetShare = 0.5
+)
+
+// quotaConfig configures the request budget of an access token.
+type quotaConfig struct {
+	RequestsPerHour int `json:"requests_per_hour"`
+}
+
+// quota tracks the requests made with an access token over the last hour.
+type quota struct {
+	key             string
+	requestsPerHour int
+	requests        []time.Time
+}
+
+// quotaManager spreads the request budget of each access token across the
+// stops that use it. Each stop gets a share of the budget proportional to its
+// priority, which is boosted during its peak hours, and may be refreshed at
+// most once per the resulting interval.
+type quotaManager struct {
+	quotas map[string]*quota
+	// stopRequests maps each stop to the number of requests a refresh of it
+	// makes with each access token.
+	stopRequests map[string]map[string]int
+	stops        map[string]Stop
+}
+
+func newQuotaManager(config map[string]quotaConfig, stops map[string]Stop, stopRequests map[string]map[string]int) (*quotaManager, error) {
+	q := &quotaManager{
+		quotas:       make(map[string]*quota),
+		stopRequests: stopRequests,
+		stops:        stops,
+	}
+	for key, c := range config {
+		if c.RequestsPerHour <= 0 {
+			return nil, fmt.Errorf("Quota for %s must allow at least one request per hour", key)
+		}
+		q.quotas[key] = &quota{key: key, requestsPerHour: c.RequestsPerHour}
+	}
+	for k, s := range stops {
+		for _, hours := range s.PeakHours {
+			if _, _, err := parseHourRange(hours); err != nil {
+				return nil, fmt.Errorf("Stop %q: %s", k, err.Error())
+			}
+		}
+	}
+	return q, nil
+}
+
+// interval returns the minimum time between refreshes of the stop at the
+// given time. It's zero if the stop doesn't use any budgeted access tokens.
+func (q *quotaManager) interval(key string, now time.Time) time.Duration {
+	var res time.Duration
+	for token, requests := range q.stopRequests[key] {
+		quota, ok := q.quotas[token]
+		if !ok {
+			continue
+		}
+
+		var total float64
+		for k := range q.stops {
+			if q.stopRequests[k][token] > 0 {
+				total += q.weight(k, now) * float64(q.stopRequests[k][token])
+			}
+		}
+		share := q.weight(key, now) * float64(requests) / total
+		refreshesPerHour := float64(quota.requestsPerHour) * share / float64(requests)
+		interval := time.Duration(float64(quotaWindow) / refreshesPerHour)
+
+		// Never exceed the budget, even if the usage so far was uneven.
+		if wait := quota.wait(requests, now); wait > interval {
+			interval = wait
+		}
+		if interval > res {
+			res = interval
+		}
+	}
+	return res
+}
+
+// record counts the requests made by a refresh of the stop.
+func (q *quotaManager) record(key string, now time.Time) {
+	for token, requests := range q.stopRequests[key] {
+		if quota, ok := q.quotas[token]; ok {
+			for i := 0; i < requests; i++ {
+				quota.requests = append(quota.requests, now)
+			}
+		}
+	}
+}
+
+// take counts a request made with the access token other than by a stop
+// refresh, ex. by the stop catalog, if it leaves the given share of the
+// token's budget unused over the last hour. It returns false if it doesn't.
+func (q *quotaManager) take(token string, share float64, now time.Time) bool {
+	quota, ok := q.quotas[token]
+	if !ok {
+		return true
+	}
+	quota.prune(now)
+	if float64(len(quota.requests)+1) > share*float64(quota.requestsPerHour) {
+		return false
+	}
+	quota.requests = append(quota.requests, now)
+	return true
+}
+
+// weight returns the stop's priority at the given time.
+func (q *quotaManager) weight(key string, now time.Time) float64 {
+	stop := q.stops[key]
+	weight := float64(stop.priority())
+	for _, hours := range stop.PeakHours {
+		if start, end, err := parseHourRange(hours); err == nil && inHourRange(now.Hour(), start, end) {
+			return weight * peakMultiplier
+		}
+	}
+	return weight
+}
+
+// wait returns how long until the given number of requests can be made
+// without exceeding the budget.
+func (q *quota) wait(requests int, now time.Time) time.Duration {
+	q.prune(now)
+	cutoff := now.Add(-quotaWindow)
+	excess := len(q.requests) + requests - q.requestsPerHour
+	if excess <= 0 {
+		return 0
+	}
+	if excess > len(q.requests) {
+		return quotaWindow
+	}
+	return q.requests[excess-1].Sub(cutoff)
+}
+
+// prune forgets requests made more than quotaWindow ago.
+func (q *quota) prune(now time.Time) {
+	cutoff := now.Add(-quotaWindow)
+	for len(q.requests) > 0 && !q.requests[0].After(cutoff) {
+		q.requests = q.requests[1:]
+	}
+}
+
+// parseHourRange parses a range of hours of the form "7-10", which includes
+// the hours from 7:00 until 10:00. Ranges may wrap around midnight, ex.
+// "23-6", but may not be empty, ex. "8-8".
+func parseHourRange(s string) (start, end int, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid hour range %q", s)
+	}
+	start, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || start < 0 || start > 23 {
+		return 0, 0, fmt.Errorf("invalid hour range %q", s)
+	}
+	end, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || end < 0 || end > 24 {
+		return 0, 0, fmt.Errorf("invalid hour range %q", s)
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("hour range %q is empty", s)
+	}
+	return start, end, nil
+}
+
+// inHourRange returns true if the hour is within the range from start until
+// end.
+func inHourRange(hour, start, end int) bool {
+	if start <= end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
@@ -0,0 +1,139 @@
+package predictions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaTake(t *testing.T) {
+	now := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	q, err := newQuotaManager(map[string]quotaConfig{"511.org": {RequestsPerHour: 10}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only half the budget may be taken.
+	for i := 0; i < 5; i++ {
+		if !q.take("511.org", 0.5, now) {
+			t.Fatalf("request %d was refused, expected 5 to be allowed", i+1)
+		}
+	}
+	if q.take("511.org", 0.5, now) {
+		t.Error("expected the sixth request to be refused")
+	}
+	if !q.take("511.org", 0.5, now.Add(quotaWindow)) {
+		t.Error("expected a request to be allowed an hour later")
+	}
+	if !q.take("other", 0.5, now) {
+		t.Error("expected requests with an unbudgeted token to be allowed")
+	}
+}
+
+func TestQuotaInterval(t *testing.T) {
+	stops := map[string]Stop{
+		"judah":  {Route: "N", Code: 15201, PeakHours: []string{"7-10"}},
+		"church": {Route: "J", Code: 14006},
+		"castro": {Route: "K", Code: 15728, Priority: 2},
+		"local":  {Route: "N", Code: 16630},
+	}
+	stopRequests := map[string]map[string]int{
+		"judah":  {"511.org": 1},
+		"church": {"511.org": 1},
+		// A fallback across two sources that use the token makes a
+		// request with each.
+		"castro": {"511.org": 2},
+		"local":  {"gtfs": 1},
+	}
+	q, err := newQuotaManager(map[string]quotaConfig{"511.org": {RequestsPerHour: 120}}, stops, stopRequests)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	afternoon := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	morning := time.Date(2016, 6, 30, 8, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		stop     string
+		now      time.Time
+		interval time.Duration
+	}{
+		// The weights are 1, 1 and 2 × 2 requests, so the budget is split
+		// 1:1:4, and the K's refreshes make two requests each.
+		{"judah", afternoon, 3 * time.Minute},
+		{"church", afternoon, 3 * time.Minute},
+		{"castro", afternoon, 90 * time.Second},
+		// During its peak hours, the N gets four times the weight: 4:1:4.
+		{"judah", morning, 67500 * time.Millisecond},
+		{"church", morning, 270 * time.Second},
+		{"castro", morning, 135 * time.Second},
+		// The stop doesn't use a budgeted token.
+		{"local", afternoon, 0},
+	}
+	for _, tc := range testCases {
+		if interval := q.interval(tc.stop, tc.now); interval != tc.interval {
+			t.Errorf("%s at %s: got %s, expected %s", tc.stop, tc.now.Format("15:04"), interval, tc.interval)
+		}
+	}
+
+	// Once the budget is spent, stops wait for the oldest requests to
+	// expire.
+	for i := 0; i < 60; i++ {
+		q.record("castro", afternoon)
+	}
+	if interval := q.interval("judah", afternoon.Add(time.Minute)); interval != 59*time.Minute {
+		t.Errorf("got %s after the budget was spent, expected 59m", interval)
+	}
+}
+
+func TestSourceRequests(t *testing.T) {
+	m := newSourceModule(map[string]string{"511.org": "token"}, map[string]string{
+		"fallback": `{"sources": ["511-siri", "511.org"]}`,
+	})
+	if _, err := m.source("fallback", nil); err != nil {
+		t.Fatal(err)
+	}
+	if requests := m.sourceRequests["fallback"]["511.org"]; requests != 2 {
+		t.Errorf("got %d requests per fallback prediction, expected 2", requests)
+	}
+	if requests := m.sourceRequests["511-siri"]["511.org"]; requests != 1 {
+		t.Errorf("got %d requests per SIRI prediction, expected 1", requests)
+	}
+}
+
+func TestDueStopsThrottledByQuota(t *testing.T) {
+	stops := map[string]Stop{
+		"judah":  {Route: "N", Code: 15201, Source: "511.org"},
+		"church": {Route: "J", Code: 14006, Source: "511.org"},
+	}
+	m := newTestModule(t, stops, nil)
+	var err error
+	m.quotas, err = newQuotaManager(map[string]quotaConfig{"511.org": {RequestsPerHour: 60}}, stops,
+		map[string]map[string]int{"judah": {"511.org": 1}, "church": {"511.org": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each stop gets half the budget, so it may be refreshed every other
+	// minute.
+	now := time.Now()
+	for _, tc := range []struct {
+		after time.Duration
+		due   int
+	}{
+		{0, 2},
+		{time.Minute, 0},
+		{2 * time.Minute, 2},
+	} {
+		at := now.Add(tc.after)
+		keys := m.dueStops(at)
+		if len(keys) != tc.due {
+			t.Errorf("after %s: got %d stops due, expected %d", tc.after, len(keys), tc.due)
+		}
+		for _, key := range keys {
+			m.recordRefresh(key, at, nil)
+			m.endRefresh(key)
+		}
+	}
+	if requests := len(m.quotas.quotas["511.org"].requests); requests != 4 {
+		t.Errorf("got %d requests counted against the budget, expected 4", requests)
+	}
+}
@@ -0,0 +1,194 @@
+package predictions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	recordingFileLayout  = "20060102T150405.000000000"
+	defaultMaxRecordings = 10000
+	defaultRecordingAge  = 24 * time.Hour
+	// pruneInterval is how often old recordings are deleted.
+	pruneInterval = time.Minute
+)
+
+var (
+	// credentialParams are query parameters holding access tokens, which are
+	// left out of recordings.
+	credentialParams = []string{"token", "api_key", "key"}
+)
+
+// recordingConfig configures recording of upstream requests. If Dir is set,
+// every request to an upstream source and its response are written to it.
+// Only the newest MaxFiles recordings from the last MaxAge are kept.
+type recordingConfig struct {
+	Dir      string   `json:"dir"`
+	MaxFiles int      `json:"max_files"`
+	MaxAge   duration `json:"max_age"`
+}
+
+// recording is a single upstream request and its response.
+type recording struct {
+	RequestedAt time.Time   `json:"requested_at"`
+	RespondedAt time.Time   `json:"responded_at"`
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	Error       string      `json:"error,omitempty"`
+	StatusCode  int         `json:"status_code,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// recorder writes upstream requests and responses to a rolling directory.
+type recorder struct {
+	dir      string
+	maxFiles int
+	maxAge   time.Duration
+	seq      uint64
+
+	mu       sync.Mutex
+	prunedAt time.Time
+}
+
+func newRecorder(c recordingConfig) (*recorder, error) {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create recording directory: %s", err.Error())
+	}
+	r := &recorder{
+		dir:      c.Dir,
+		maxFiles: c.MaxFiles,
+		maxAge:   time.Duration(c.MaxAge),
+	}
+	if r.maxFiles <= 0 {
+		r.maxFiles = defaultMaxRecordings
+	}
+	if r.maxAge <= 0 {
+		r.maxAge = defaultRecordingAge
+	}
+	fmt.Printf("Recording upstream requests to %s\n", r.dir)
+	return r, nil
+}
+
+// transport wraps an http.RoundTripper so that every request made through it
+// is recorded.
+func (r *recorder) transport(next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{recorder: r, next: next}
+}
+
+type recordingTransport struct {
+	recorder *recorder
+	next     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := recording{
+		RequestedAt: time.Now(),
+		Method:      req.Method,
+		URL:         redactURL(req.URL),
+	}
+	resp, err := t.next.RoundTrip(req)
+	rec.RespondedAt = time.Now()
+	if err != nil {
+		rec.Error = err.Error()
+		t.recorder.write(rec)
+		return nil, err
+	}
+
+	// Read the whole body so it can be recorded, and hand the caller a copy.
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	rec.RespondedAt = time.Now()
+	rec.StatusCode = resp.StatusCode
+	rec.Header = resp.Header
+	rec.Body = body
+	if err != nil {
+		rec.Error = err.Error()
+		t.recorder.write(rec)
+		return nil, err
+	}
+	t.recorder.write(rec)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// write saves the recording, logging rather than failing the request if it
+// can't be saved.
+func (r *recorder) write(rec recording) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error recording %s: %s\n", rec.URL, err.Error())
+		return
+	}
+	seq := atomic.AddUint64(&r.seq, 1)
+	name := fmt.Sprintf("%s-%d.json", rec.RequestedAt.UTC().Format(recordingFileLayout), seq)
+	if err := ioutil.WriteFile(filepath.Join(r.dir, name), b, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error recording %s: %s\n", rec.URL, err.Error())
+		return
+	}
+	r.prune(rec.RespondedAt)
+}
+
+// prune deletes recordings beyond the configured number or age. It does so at
+// most once per pruneInterval.
+func (r *recorder) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.prunedAt) < pruneInterval {
+		return
+	}
+	r.prunedAt = now
+
+	names, err := recordingFiles(r.dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error pruning recordings: %s\n", err.Error())
+		return
+	}
+	cutoff := now.Add(-r.maxAge).UTC().Format(recordingFileLayout)
+	for i, name := range names {
+		if len(names)-i <= r.maxFiles && name >= cutoff {
+			break
+		}
+		os.Remove(filepath.Join(r.dir, name))
+	}
+}
+
+// recordingFiles returns the names of the recordings in the directory, oldest
+// first.
+func recordingFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// redactURL returns the URL without any access tokens, so that recordings can
+// be shared and matched up regardless of the token used.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	queryParams := redacted.Query()
+	for _, param := range credentialParams {
+		queryParams.Del(param)
+	}
+	redacted.RawQuery = queryParams.Encode()
+	return redacted.String()
+}
@@ -0,0 +1,100 @@
+package predictions
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// failingBody returns part of a response before failing.
+type failingBody struct {
+	read   bool
+	closed bool
+}
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	if b.read {
+		return 0, errors.New("connection reset by peer")
+	}
+	b.read = true
+	return copy(p, "<RTT>"), nil
+}
+
+func (b *failingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRecordingTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, err := newRecorder(recordingConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body *failingBody
+	transport := r.transport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if body != nil {
+			return &http.Response{StatusCode: http.StatusOK, Body: body}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("<RTT></RTT>"))}, nil
+	}))
+
+	req, err := http.NewRequest("GET", "http://services.my511.org/Transit2.0/GetNextDeparturesByStopCode.aspx?token=secret&stopcode=15201", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(resp.Body); err != nil || string(b) != "<RTT></RTT>" {
+		t.Errorf("got body %q and error %v, expected the upstream's body", b, err)
+	}
+
+	// A body that fails partway fails the request.
+	body = &failingBody{}
+	resp, err = transport.RoundTrip(req)
+	if resp != nil || err == nil {
+		t.Errorf("got response %v and error %v, expected only an error", resp, err)
+	}
+	if !body.closed {
+		t.Error("expected the failed body to be closed")
+	}
+
+	names, err := recordingFiles(dir)
+	if err != nil || len(names) != 2 {
+		t.Fatalf("got %d recordings and error %v, expected 2", len(names), err)
+	}
+	var recordings []recording
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec recording
+		if err := json.Unmarshal(b, &rec); err != nil {
+			t.Fatal(err)
+		}
+		recordings = append(recordings, rec)
+	}
+	if rec := recordings[0]; rec.Error != "" || string(rec.Body) != "<RTT></RTT>" || strings.Contains(rec.URL, "secret") {
+		t.Errorf("unexpected recording %+v", rec)
+	}
+	if rec := recordings[1]; rec.Error != "connection reset by peer" || string(rec.Body) != "<RTT>" {
+		t.Errorf("unexpected recording of the failed body %+v", rec)
+	}
+}
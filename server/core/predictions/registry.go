@@ -0,0 +1,140 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sourceFactory builds a predictor from the source's options in config.json.
+// The options may be nil if the source isn't configured.
+type sourceFactory func(c *sourceContext, options json.RawMessage) (Predictor, error)
+
+var (
+	// sourceFactories maps each kind of source to a factory for it. Stops
+	// name their source as "kind" or "kind:instance", ex. "gtfs-rt:muni",
+	// so that one kind of source can be configured several times.
+	sourceFactories = map[string]sourceFactory{
+		source511:          new511Predictor,
+		sourceSIRI:         newSIRIPredictor,
+		sourceGTFSRealtime: newGTFSRealtimeSource,
+		sourceGTFSSchedule: newGTFSScheduleSource,
+		sourceFallback:     newFallbackSource,
+		sourceEnsemble:     newEnsembleSource,
+		sourceCorrected:    newCorrectedSource,
+	}
+)
+
+// sourceContext provides factories with what they need to build a source.
+type sourceContext struct {
+	// ctx is cancelled when the module stops, for sources that load feeds
+	// while they're built.
+	ctx           context.Context
+	name          string
+	keys          map[string]string
+	routeVariants routeVariants
+	stops         map[string]Stop
+	// upstream is the configuration of requests to every source.
+	upstream upstreamConfig
+	// recorder records requests to the source, if recording is enabled.
+	recorder *recorder
+	// transport, if set, replaces requests to the source, ex. with
+	// recordings. While replaying, access tokens are optional.
+	transport http.RoundTripper
+	replaying bool
+	// options maps every source's name to its options in config.json.
+	options map[string]json.RawMessage
+	// history holds past predictions, if it's enabled.
+	history *historyStore
+	// location returns the timezone of the agency, once it's known.
+	location func() *time.Location
+	// requests counts the requests that a single prediction from the source
+	// makes with each access token.
+	requests map[string]int
+	// source returns the predictor with the given name, for sources that
+	// combine other sources.
+	source func(name string) (Predictor, error)
+}
+
+// sourceKind returns the kind of source from its name.
+func sourceKind(name string) string {
+	return strings.SplitN(name, ":", 2)[0]
+}
+
+// consumes records that every prediction from the source makes a request
+// with the access token.
+func (c *sourceContext) consumes(key string) {
+	c.requests[key]++
+}
+
+// decodeOptions decodes the source's options into dst, if there are any.
+func decodeOptions(options json.RawMessage, dst interface{}) error {
+	if len(options) == 0 {
+		return nil
+	}
+	return json.Unmarshal(options, dst)
+}
+
+// token returns the access token with the given name from keys.json.
+func (c *sourceContext) token(key string) (string, error) {
+	token, ok := c.keys[key]
+	if !ok && !c.replaying {
+		return "", fmt.Errorf("No %s access token provided in keys.json", key)
+	}
+	return token, nil
+}
+
+// client returns a client for requests to the source, configured by the
+// source's options and otherwise by config.json.
+func (c *sourceContext) client(options upstreamConfig) *upstreamClient {
+	client := newUpstreamClient(options.merge(c.upstream))
+	if c.transport != nil {
+		client.client.Transport = c.transport
+	} else if c.recorder != nil {
+		client.client.Transport = c.recorder.transport(client.client.Transport)
+	}
+	return client
+}
+
+// feedLocation returns the URL or path of the configured feed, including the
+// access token if one is required.
+func (c *sourceContext) feedLocation(f feedConfig) (string, error) {
+	if f.Feed == "" {
+		return "", fmt.Errorf("Source %q has no feed configured", c.name)
+	}
+	if f.Key == "" {
+		return f.Feed, nil
+	}
+	token, err := c.token(f.Key)
+	if err != nil {
+		return "", err
+	}
+	l, err := url.Parse(f.Feed)
+	if err != nil {
+		return "", err
+	}
+	queryParams := l.Query()
+	queryParams.Set("api_key", token)
+	l.RawQuery = queryParams.Encode()
+	return l.String(), nil
+}
+
+// sources returns the predictors with the given names.
+func (c *sourceContext) sources(names []string) ([]Predictor, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("Source %q has no sources configured", c.name)
+	}
+	var predictors []Predictor
+	for _, name := range names {
+		predictor, err := c.source(name)
+		if err != nil {
+			return nil, err
+		}
+		predictors = append(predictors, predictor)
+	}
+	return predictors, nil
+}
@@ -0,0 +1,82 @@
+package predictions
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// newSourceModule returns a module with the given access tokens and source
+// options, ready to build sources.
+func newSourceModule(keys map[string]string, sources map[string]string) *Module {
+	m := &Module{
+		ctx:                context.Background(),
+		keys:               keys,
+		predictors:         make(map[string]Predictor),
+		sourceRequests:     make(map[string]map[string]int),
+		sourceDependencies: make(map[string][]string),
+	}
+	m.config.Sources = make(map[string]json.RawMessage)
+	for name, options := range sources {
+		m.config.Sources[name] = json.RawMessage(options)
+	}
+	return m
+}
+
+func TestSourceRegistry(t *testing.T) {
+	m := newSourceModule(map[string]string{"511.org": "secret"}, map[string]string{
+		"gtfs-rt:muni":   `{"feed": "../../fixtures/trip_updates.pb"}`,
+		"fallback:muni":  `{"sources": ["511-siri", "gtfs-rt:muni", "511.org"]}`,
+		"fallback:empty": `{}`,
+		"fallback:loop":  `{"sources": ["511.org", "fallback:loop"]}`,
+		"fallback:a":     `{"sources": ["fallback:b"]}`,
+		"fallback:b":     `{"sources": ["fallback:a"]}`,
+		"511.org:proxy":  `{"url": "http://localhost:8511", "key": "proxy"}`,
+		"511.org:broken": `{"url": 511}`,
+	})
+
+	testCases := []struct {
+		name string
+		// err is part of the expected error, if building the source
+		// should fail.
+		err string
+	}{
+		{"511.org", ""},
+		{"511-siri", ""},
+		{"gtfs-rt:muni", ""},
+		{"fallback:muni", ""},
+		{"gtfs-rt:bart", `Source "gtfs-rt:bart" has no feed configured`},
+		{"511.org:proxy", "No proxy access token provided in keys.json"},
+		{"511.org:broken", `Error configuring source "511.org:broken"`},
+		{"fallback:empty", `Source "fallback:empty" has no sources configured`},
+		{"fallback:loop", `Source "fallback:loop" depends on itself`},
+		{"fallback:a", `Source "fallback:a" depends on itself`},
+		{"bart", `Unknown source "bart"`},
+	}
+	for _, tc := range testCases {
+		predictor, err := m.source(tc.name, nil)
+		if tc.err == "" {
+			if err != nil || predictor == nil {
+				t.Errorf("%s: got error %v, expected a predictor", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, expected %q", tc.name, err, tc.err)
+		}
+	}
+
+	// Each kind of source gets its own predictor, and sources are built
+	// once however many others combine them.
+	if _, ok := m.predictors["gtfs-rt:muni"].(*gtfsRealtimePredictor); !ok {
+		t.Errorf("got %T for gtfs-rt:muni", m.predictors["gtfs-rt:muni"])
+	}
+	if d, ok := m.predictors["511.org"].(*defaultPredictor); !ok || d.accessToken != "secret" {
+		t.Errorf("got %+v for 511.org", m.predictors["511.org"])
+	}
+	f, ok := m.predictors["fallback:muni"].(*fallbackPredictor)
+	if !ok || len(f.predictors) != 3 || f.predictors[1] != m.predictors["gtfs-rt:muni"] {
+		t.Errorf("expected the fallback to combine the built sources, got %+v", m.predictors["fallback:muni"])
+	}
+}
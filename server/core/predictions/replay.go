@@ -0,0 +1,213 @@
+package predictions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replayConfig configures a "replay" source, which serves the recordings in
+// Dir to a source of the kind named by Source, ex. "511.org", in place of the
+// real upstream. Time starts at Start, or the first recording, and runs Speed
+// times faster than real time.
+type replayConfig struct {
+	Dir    string  `json:"dir"`
+	Source string  `json:"source"`
+	Speed  float64 `json:"speed"`
+	Start  string  `json:"start"`
+}
+
+// replayPredictor predicts departures by replaying recorded upstream
+// responses to a regular source, so that the real parsing code runs against
+// them.
+type replayPredictor struct {
+	predictor Predictor
+	// recordings maps redacted requests to their recordings, oldest first.
+	recordings map[string][]recording
+	start      time.Time
+	speed      float64
+
+	mu        sync.Mutex
+	startedAt time.Time
+}
+
+var _ Predictor = &replayPredictor{}
+
+func init() {
+	// Registered here rather than with the other sources since it builds
+	// sources from sourceFactories itself.
+	sourceFactories[sourceReplay] = newReplaySource
+}
+
+// newReplaySource loads the recordings in the source's options and builds the
+// source they're replayed to.
+func newReplaySource(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	var o replayConfig
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	if o.Dir == "" {
+		return nil, fmt.Errorf("Source %q has no recording directory configured", c.name)
+	}
+	switch sourceKind(o.Source) {
+	case "":
+		return nil, fmt.Errorf("Source %q has no source to replay to", c.name)
+	case sourceFallback, sourceEnsemble, sourceReplay:
+		return nil, fmt.Errorf("Source %q can't replay to %q; replay to each of its sources instead", c.name, o.Source)
+	}
+	factory, ok := sourceFactories[sourceKind(o.Source)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown source %q", o.Source)
+	}
+	if o.Speed <= 0 {
+		o.Speed = 1
+	}
+
+	r := &replayPredictor{
+		recordings: make(map[string][]recording),
+		speed:      o.Speed,
+	}
+	if err := r.load(o.Dir); err != nil {
+		return nil, err
+	}
+	if o.Start != "" {
+		start, err := time.Parse(time.RFC3339, o.Start)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid replay start %q", o.Start)
+		}
+		r.start = start
+	}
+
+	replayed := *c
+	replayed.name = o.Source
+	replayed.requests = make(map[string]int)
+	replayed.transport = &replayTransport{replay: r}
+	replayed.replaying = true
+	predictor, err := factory(&replayed, c.options[o.Source])
+	if err != nil {
+		return nil, err
+	}
+	r.predictor = predictor
+	return r, nil
+}
+
+// load reads the recordings in the directory.
+func (r *replayPredictor) load(dir string) error {
+	names, err := recordingFiles(dir)
+	if err != nil {
+		return err
+	}
+	var first time.Time
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		var rec recording
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+		key := rec.Method + " " + rec.URL
+		r.recordings[key] = append(r.recordings[key], rec)
+		if first.IsZero() || rec.RequestedAt.Before(first) {
+			first = rec.RequestedAt
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("No recordings in %s", dir)
+	}
+	for _, recordings := range r.recordings {
+		sort.Sort(byRequestTime(recordings))
+	}
+	r.start = first
+	fmt.Printf("Replaying %d recordings from %s\n", len(names), dir)
+	return nil
+}
+
+// now returns the current time in the replay. The replay starts the first
+// time it's needed.
+func (r *replayPredictor) now() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.startedAt.IsZero() {
+		r.startedAt = time.Now()
+	}
+	elapsed := time.Duration(float64(time.Since(r.startedAt)) * r.speed)
+	return r.start.Add(elapsed)
+}
+
+func (r *replayPredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	return r.predictor.Predict(withClock(ctx, r.now), stop)
+}
+
+// find returns the most recent recording of the request as of the given time.
+func (r *replayPredictor) find(req *http.Request, now time.Time) (recording, bool) {
+	recordings := r.recordings[req.Method+" "+redactURL(req.URL)]
+	i := sort.Search(len(recordings), func(i int) bool {
+		return recordings[i].RequestedAt.After(now)
+	})
+	if i == 0 {
+		return recording{}, false
+	}
+	return recordings[i-1], true
+}
+
+// replayTransport is an http.RoundTripper that responds with recordings
+// instead of making requests.
+type replayTransport struct {
+	replay *replayPredictor
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	now := t.replay.now()
+	rec, ok := t.replay.find(req, now)
+	if !ok {
+		return nil, fmt.Errorf("No recording of %s %s before %s", req.Method, redactURL(req.URL), now.Format(time.RFC3339))
+	}
+	if rec.StatusCode == 0 {
+		return nil, errors.New(rec.Error)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.StatusCode, http.StatusText(rec.StatusCode)),
+		StatusCode:    rec.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(rec.Body)),
+		ContentLength: int64(len(rec.Body)),
+		Request:       req,
+	}, nil
+}
+
+// byRequestTime sorts recordings by when their requests were made.
+type byRequestTime []recording
+
+func (r byRequestTime) Len() int           { return len(r) }
+func (r byRequestTime) Less(i, j int) bool { return r[i].RequestedAt.Before(r[j].RequestedAt) }
+func (r byRequestTime) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+type clockKey struct{}
+
+// withClock returns a context in which predictors take the current time from
+// now, so that replayed departures are predicted as of when they were
+// recorded.
+func withClock(ctx context.Context, now func() time.Time) context.Context {
+	return context.WithValue(ctx, clockKey{}, now)
+}
+
+// clock returns the current time according to the context.
+func clock(ctx context.Context) time.Time {
+	if now, ok := ctx.Value(clockKey{}).(func() time.Time); ok {
+		return now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,104 @@
+package predictions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	scheduleDateLayout = "2006-01-02"
+)
+
+var (
+	scheduleDays = map[string][]time.Weekday{
+		"sun":      {time.Sunday},
+		"mon":      {time.Monday},
+		"tue":      {time.Tuesday},
+		"wed":      {time.Wednesday},
+		"thu":      {time.Thursday},
+		"fri":      {time.Friday},
+		"sat":      {time.Saturday},
+		"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		"weekends": {time.Saturday, time.Sunday},
+	}
+)
+
+// ScheduleRule configures how often to refresh predictions at certain times.
+// Days (ex. "mon" or "weekends"), Hours (ex. "7-10") and Dates (ex.
+// "2016-12-24..2017-01-01") restrict when the rule applies, and are
+// unrestricted if empty.
+type ScheduleRule struct {
+	Days     []string `json:"days,omitempty"`
+	Hours    string   `json:"hours,omitempty"`
+	Dates    string   `json:"dates,omitempty"`
+	Interval duration `json:"interval"`
+}
+
+// parseSchedule builds an update interval from an ordered list of rules. The
+// first rule that applies decides how often to refresh, and fallback decides
+// at times that none of them cover.
+func parseSchedule(rules []ScheduleRule, fallback updateInterval) (updateInterval, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("schedule has no rules")
+	}
+
+	var intervals []updateInterval
+	for i, rule := range rules {
+		ruleInterval, err := rule.updateInterval()
+		if err != nil {
+			return nil, fmt.Errorf("schedule rule %d: %s", i+1, err.Error())
+		}
+		intervals = append(intervals, ruleInterval)
+	}
+	return composite(append(intervals, fallback)...), nil
+}
+
+func (r ScheduleRule) updateInterval() (updateInterval, error) {
+	if r.Interval <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	res := interval(time.Duration(r.Interval))
+
+	if r.Dates != "" {
+		parts := strings.SplitN(r.Dates, "..", 2)
+		from, err := time.Parse(scheduleDateLayout, strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dates %q", r.Dates)
+		}
+		to := from
+		if len(parts) == 2 {
+			if to, err = time.Parse(scheduleDateLayout, strings.TrimSpace(parts[1])); err != nil {
+				return nil, fmt.Errorf("invalid dates %q", r.Dates)
+			}
+		}
+		if to.Before(from) {
+			return nil, fmt.Errorf("invalid dates %q: ends before it starts", r.Dates)
+		}
+		res = betweenDates(from.Format(scheduleDateLayout), to.Format(scheduleDateLayout), res)
+	}
+
+	if r.Hours != "" {
+		start, end, err := parseHourRange(r.Hours)
+		if err != nil {
+			return nil, err
+		}
+		res = inHours(start, end, res)
+	}
+
+	if len(r.Days) > 0 {
+		days := make(map[time.Weekday]bool)
+		for _, day := range r.Days {
+			weekdays, ok := scheduleDays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %q", day)
+			}
+			for _, weekday := range weekdays {
+				days[weekday] = true
+			}
+		}
+		res = onDays(days, res)
+	}
+	return res, nil
+}
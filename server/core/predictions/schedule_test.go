@@ -0,0 +1,80 @@
+package predictions
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	testCases := []struct {
+		rule ScheduleRule
+		err  string
+	}{
+		{ScheduleRule{Hours: "8-8", Interval: duration(time.Minute)}, "empty"},
+		{ScheduleRule{Hours: "0-0", Interval: duration(time.Minute)}, "empty"},
+		{ScheduleRule{Hours: "24-7", Interval: duration(time.Minute)}, "invalid hour range"},
+		{ScheduleRule{Hours: "7-25", Interval: duration(time.Minute)}, "invalid hour range"},
+		{ScheduleRule{Hours: "7", Interval: duration(time.Minute)}, "invalid hour range"},
+		{ScheduleRule{Hours: "7-10"}, "interval must be positive"},
+		{ScheduleRule{Days: []string{"mondays"}, Interval: duration(time.Minute)}, "unknown day"},
+		{ScheduleRule{Dates: "2017-01-01..2016-12-24", Interval: duration(time.Minute)}, "ends before it starts"},
+		{ScheduleRule{Dates: "2016-12-24..", Interval: duration(time.Minute)}, "invalid dates"},
+	}
+	for _, tc := range testCases {
+		_, err := parseSchedule([]ScheduleRule{tc.rule}, defaultInterval)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%+v: got error %v, expected %q", tc.rule, err, tc.err)
+		}
+	}
+
+	if _, err := parseSchedule(nil, defaultInterval); err == nil {
+		t.Error("expected an error for a schedule without rules")
+	}
+	for _, hours := range []string{"23-7", "0-24", "7-10", "22-0"} {
+		if _, err := parseSchedule([]ScheduleRule{{Hours: hours, Interval: duration(time.Minute)}}, defaultInterval); err != nil {
+			t.Errorf("%s: %s", hours, err)
+		}
+	}
+}
+
+func TestParseScheduleFallback(t *testing.T) {
+	global, err := parseSchedule([]ScheduleRule{
+		{Days: []string{"weekends"}, Interval: duration(2 * time.Minute)},
+	}, interval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := parseSchedule([]ScheduleRule{
+		{Days: []string{"weekdays"}, Hours: "8-11", Interval: duration(10 * time.Second)},
+	}, global)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monday := time.Date(2016, 6, 27, 0, 0, 0, 0, time.UTC)
+	saturday := time.Date(2016, 6, 25, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name  string
+		now   time.Time
+		since time.Duration
+		due   bool
+	}{
+		{"stop rule", monday.Add(9 * time.Hour), 15 * time.Second, true},
+		{"stop rule not due", monday.Add(9 * time.Hour), 5 * time.Second, false},
+		// Weekday afternoons aren't covered by either schedule's rules.
+		{"default", monday.Add(14 * time.Hour), 30 * time.Second, false},
+		{"default due", monday.Add(14 * time.Hour), 90 * time.Second, true},
+		{"global rule", saturday.Add(9 * time.Hour), 90 * time.Second, false},
+		{"global rule due", saturday.Add(9 * time.Hour), 150 * time.Second, true},
+	}
+	m := &Module{}
+	for _, tc := range testCases {
+		due := due(stop, tc.now, tc.now.Add(-tc.since), m)
+		if due == nil {
+			t.Errorf("%s: the schedule doesn't cover %s", tc.name, tc.now)
+		} else if *due != tc.due {
+			t.Errorf("%s: got due %t, expected %t", tc.name, *due, tc.due)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package predictions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSIRIURL = "http://api.511.org/transit/StopMonitoring"
+)
+
+var (
+	// siriAgencies maps the agency names used by the legacy 511 API to the
+	// operator codes used by the SIRI API.
+	siriAgencies = map[string]string{
+		"SF-MUNI":    "SF",
+		"BART":       "BA",
+		"AC Transit": "AC",
+		"Caltrain":   "CT",
+		"VTA":        "SC",
+	}
+
+	utf8BOM = []byte("\xef\xbb\xbf")
+)
+
+// siriTime is a timestamp that may be empty.
+type siriTime struct {
+	time.Time
+}
+
+func (t *siriTime) UnmarshalJSON(b []byte) error {
+	if string(b) == `""` || string(b) == "null" {
+		return nil
+	}
+	return t.Time.UnmarshalJSON(b)
+}
+
+type siriCall struct {
+	StopPointRef          string   `json:"StopPointRef"`
+	DestinationDisplay    string   `json:"DestinationDisplay"`
+	AimedArrivalTime      siriTime `json:"AimedArrivalTime"`
+	ExpectedArrivalTime   siriTime `json:"ExpectedArrivalTime"`
+	AimedDepartureTime    siriTime `json:"AimedDepartureTime"`
+	ExpectedDepartureTime siriTime `json:"ExpectedDepartureTime"`
+}
+
+type siriVehicleJourney struct {
+	LineRef                 string `json:"LineRef"`
+	DirectionRef            string `json:"DirectionRef"`
+	PublishedLineName       string `json:"PublishedLineName"`
+	DestinationName         string `json:"DestinationName"`
+	VehicleRef              string `json:"VehicleRef"`
+	FramedVehicleJourneyRef struct {
+		DatedVehicleJourneyRef string `json:"DatedVehicleJourneyRef"`
+	} `json:"FramedVehicleJourneyRef"`
+	MonitoredCall siriCall `json:"MonitoredCall"`
+}
+
+type stopMonitoringResponse struct {
+	ServiceDelivery struct {
+		ResponseTimestamp      siriTime `json:"ResponseTimestamp"`
+		StopMonitoringDelivery struct {
+			MonitoredStopVisit []struct {
+				RecordedAtTime          siriTime           `json:"RecordedAtTime"`
+				MonitoringRef           string             `json:"MonitoringRef"`
+				MonitoredVehicleJourney siriVehicleJourney `json:"MonitoredVehicleJourney"`
+			} `json:"MonitoredStopVisit"`
+		} `json:"StopMonitoringDelivery"`
+	} `json:"ServiceDelivery"`
+}
+
+// siriPredictor predicts departures using the SIRI StopMonitoring endpoint of
+// the 511.org API.
+type siriPredictor struct {
+	serviceURL    string
+	client        *upstreamClient
+	agencies      map[string]string
+	routeVariants routeVariants
+}
+
+var _ Predictor = &siriPredictor{}
+
+// newSIRIPredictor builds a predictor for the 511.org SIRI API.
+func newSIRIPredictor(c *sourceContext, options json.RawMessage) (Predictor, error) {
+	o := siriConfig{feedConfig: feedConfig{Feed: defaultSIRIURL, Key: "511.org"}}
+	if err := decodeOptions(options, &o); err != nil {
+		return nil, err
+	}
+	serviceURL, err := c.feedLocation(o.feedConfig)
+	if err != nil {
+		return nil, err
+	}
+	if o.Key != "" {
+		c.consumes(o.Key)
+	}
+	return &siriPredictor{
+		serviceURL:    serviceURL,
+		client:        c.client(o.upstreamConfig),
+		agencies:      o.Agencies,
+		routeVariants: c.routeVariants,
+	}, nil
+}
+
+func (s *siriPredictor) Predict(ctx context.Context, stop *Stop) ([]Prediction, error) {
+	l, err := url.Parse(s.serviceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	agency := stop.Agency
+	if code := stop.option("agency"); code != "" {
+		agency = code
+	} else if code, ok := s.agencies[agency]; ok {
+		agency = code
+	} else if code, ok := siriAgencies[agency]; ok {
+		agency = code
+	}
+
+	queryParams := l.Query()
+	queryParams.Set("agency", agency)
+	queryParams.Set("stopCode", strconv.Itoa(stop.Code))
+	queryParams.Set("format", "json")
+	l.RawQuery = queryParams.Encode()
+
+	resp, err := s.client.do(ctx, http.MethodGet, l.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("The 511.org SIRI API responded with a non-200 status code: %v", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response stopMonitoringResponse
+	if err := json.Unmarshal(bytes.TrimPrefix(b, utf8BOM), &response); err != nil {
+		return nil, err
+	}
+	return s.parse(&response, stop, clock(ctx)), nil
+}
+
+// parse extracts the departures from the response that belong to the stop's
+// route and direction.
+func (s *siriPredictor) parse(response *stopMonitoringResponse, stop *Stop, now time.Time) []Prediction {
+	var predictions []Prediction
+	for _, visit := range response.ServiceDelivery.StopMonitoringDelivery.MonitoredStopVisit {
+		journey := visit.MonitoredVehicleJourney
+		if !s.routeVariants.matches(stop, journey.LineRef) || !matchesDirection(stop, journey.DirectionRef) {
+			continue
+		}
+
+		call := journey.MonitoredCall
+		var departsAt time.Time
+		for _, t := range []siriTime{call.ExpectedDepartureTime, call.ExpectedArrivalTime, call.AimedDepartureTime, call.AimedArrivalTime} {
+			if !t.IsZero() {
+				departsAt = t.Time
+				break
+			}
+		}
+		if departsAt.IsZero() || departsAt.Before(now) {
+			continue
+		}
+
+		recordedAt := visit.RecordedAtTime.Time
+		if recordedAt.IsZero() {
+			recordedAt = response.ServiceDelivery.ResponseTimestamp.Time
+		}
+
+		destination := journey.DestinationName
+		if call.DestinationDisplay != "" {
+			destination = call.DestinationDisplay
+		}
+
+		predictions = append(predictions, Prediction{
+			CreatedAt:     now,
+			DepartsAt:     departsAt,
+			Minutes:       int(departsAt.Sub(now) / time.Minute),
+			Stop:          stop,
+			Source:        sourceSIRI,
+			RecordedAt:    recordedAt,
+			TripID:        journey.FramedVehicleJourneyRef.DatedVehicleJourneyRef,
+			VehicleID:     journey.VehicleRef,
+			RouteCode:     journey.LineRef,
+			RouteName:     journey.PublishedLineName,
+			DirectionCode: journey.DirectionRef,
+			DirectionName: destination,
+		})
+	}
+	return predictions
+}
@@ -0,0 +1,109 @@
+package predictions
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSIRIPredict(t *testing.T) {
+	b, err := ioutil.ReadFile("../../fixtures/stop_monitoring.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, utf8BOM) {
+		t.Fatal("expected the fixture to start with a byte order mark, like the 511.org API's responses")
+	}
+	var agency string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		agency = req.URL.Query().Get("agency")
+		if req.URL.Query().Get("stopCode") != "15201" || req.URL.Query().Get("format") != "json" {
+			t.Errorf("unexpected query %q", req.URL.RawQuery)
+		}
+		rw.Write(b)
+	}))
+	defer server.Close()
+
+	s := &siriPredictor{
+		serviceURL:    server.URL + "/transit/StopMonitoring",
+		client:        newUpstreamClient(upstreamConfig{}),
+		agencies:      map[string]string{"Muni": "SF"},
+		routeVariants: routeVariants{"N": {"NX"}},
+	}
+
+	testCases := []struct {
+		name    string
+		stop    Stop
+		agency  string
+		trips   []string
+		minutes []int
+	}{
+		// Expected times are preferred to aimed ones, and departures to
+		// arrivals. The unmonitored trip only has aimed times.
+		{"N inbound", Stop{Agency: "SF-MUNI", Route: "N", Direction: "Inbound", Code: 15201}, "SF",
+			[]string{"11239834", "11239835", "11240451", "11302210", "11240600", "11240452"},
+			[]int{2, 7, 14, 4, 18, 20}},
+		{"N outbound", Stop{Agency: "SF-MUNI", Route: "N", Direction: "Outbound", Code: 15201}, "SF",
+			[]string{"11239901"}, []int{3}},
+		{"NX", Stop{Agency: "SF-MUNI", Route: "NX", Direction: "IB", Code: 15201}, "SF",
+			[]string{"11302210"}, []int{4}},
+		{"configured agency", Stop{Agency: "Muni", Route: "NX", Code: 15201}, "SF",
+			[]string{"11302210"}, []int{4}},
+		{"agency option", Stop{Agency: "SF-MUNI", Route: "NX", Code: 15201, Options: map[string]string{"agency": "SM"}}, "SM",
+			[]string{"11302210"}, []int{4}},
+		{"unknown agency", Stop{Agency: "CT", Route: "7", Code: 15201}, "CT", nil, nil},
+	}
+
+	now := time.Date(2016, 6, 30, 16, 43, 20, 0, time.UTC)
+	ctx := withClock(context.Background(), func() time.Time { return now })
+	for _, tc := range testCases {
+		stop := tc.stop
+		predictions, err := s.Predict(ctx, &stop)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if agency != tc.agency {
+			t.Errorf("%s: requested agency %q, expected %q", tc.name, agency, tc.agency)
+		}
+		var trips []string
+		var minutes []int
+		for _, p := range predictions {
+			trips = append(trips, p.TripID)
+			minutes = append(minutes, p.Minutes)
+		}
+		if !reflect.DeepEqual(trips, tc.trips) || !reflect.DeepEqual(minutes, tc.minutes) {
+			t.Errorf("%s: got trips %v minutes %v, expected trips %v minutes %v",
+				tc.name, trips, minutes, tc.trips, tc.minutes)
+		}
+	}
+
+	stop := Stop{Agency: "SF-MUNI", Route: "N", Direction: "Inbound", Code: 15201}
+	predictions, err := s.Predict(ctx, &stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Time{
+		time.Date(2016, 6, 30, 16, 45, 30, 0, time.UTC), // expected arrival
+		time.Date(2016, 6, 30, 16, 50, 40, 0, time.UTC), // expected arrival, empty departure
+		time.Date(2016, 6, 30, 16, 57, 25, 0, time.UTC),
+		time.Date(2016, 6, 30, 16, 48, 10, 0, time.UTC),
+		time.Date(2016, 6, 30, 17, 1, 30, 0, time.UTC), // expected departure
+		time.Date(2016, 6, 30, 17, 4, 0, 0, time.UTC),  // aimed departure
+	}
+	for i, p := range predictions {
+		if !p.DepartsAt.Equal(expected[i]) {
+			t.Errorf("trip %s departs at %s, expected %s", p.TripID, p.DepartsAt, expected[i])
+		}
+	}
+	first := predictions[0]
+	if first.Source != sourceSIRI || first.VehicleID != "2064" || first.RouteName != "JUDAH" ||
+		first.DirectionCode != "IB" || first.DirectionName != "Caltrain/Ball Park" ||
+		!first.RecordedAt.Equal(time.Date(2016, 6, 30, 16, 43, 5, 0, time.UTC)) {
+		t.Errorf("unexpected prediction %+v", first)
+	}
+}
@@ -0,0 +1,31 @@
+package predictions
+
+import (
+	"sort"
+	"time"
+)
+
+// byDeparture sorts predictions by the time they're expected to depart.
+type byDeparture []Prediction
+
+func (p byDeparture) Len() int           { return len(p) }
+func (p byDeparture) Less(i, j int) bool { return p[i].DepartsAt.Before(p[j].DepartsAt) }
+func (p byDeparture) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// sortPredictions fills in any missing absolute departure times and sorts the
+// predictions so that the soonest departure comes first.
+func sortPredictions(predictions []Prediction) {
+	fillDepartureTimes(predictions)
+	sort.Stable(byDeparture(predictions))
+}
+
+// fillDepartureTimes computes absolute departure times for predictions from
+// sources that only report minutes.
+func fillDepartureTimes(predictions []Prediction) {
+	for i := range predictions {
+		p := &predictions[i]
+		if p.DepartsAt.IsZero() {
+			p.DepartsAt = p.CreatedAt.Add(time.Duration(p.Minutes) * time.Minute)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package predictions
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortPredictions(t *testing.T) {
+	now := time.Date(2016, 6, 30, 16, 0, 0, 0, time.UTC)
+	predictions := []Prediction{
+		{CreatedAt: now, Minutes: 14, TripID: "n-14"},
+		{CreatedAt: now, Minutes: 2, TripID: "n-2"},
+		// Sources with absolute times keep them, however stale Minutes is.
+		{CreatedAt: now.Add(-time.Minute), DepartsAt: now.Add(5*time.Minute + 30*time.Second), Minutes: 6, TripID: "owl"},
+		{CreatedAt: now, Minutes: 7, TripID: "n-7"},
+		// Ties keep the order the source gave.
+		{CreatedAt: now, Minutes: 2, TripID: "j-2"},
+	}
+	sortPredictions(predictions)
+
+	var trips []string
+	for _, p := range predictions {
+		trips = append(trips, p.TripID)
+	}
+	if expected := []string{"n-2", "j-2", "owl", "n-7", "n-14"}; !reflect.DeepEqual(trips, expected) {
+		t.Errorf("got trips %v, expected %v", trips, expected)
+	}
+	if !predictions[0].DepartsAt.Equal(now.Add(2 * time.Minute)) {
+		t.Errorf("got %s for a departure in 2 minutes, expected %s", predictions[0].DepartsAt, now.Add(2*time.Minute))
+	}
+	if !predictions[2].DepartsAt.Equal(now.Add(5*time.Minute + 30*time.Second)) {
+		t.Errorf("expected the source's departure time to be kept, got %s", predictions[2].DepartsAt)
+	}
+}
+
+func TestCurrentIsSorted(t *testing.T) {
+	now := time.Now()
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "511.org"},
+	}, map[string]Predictor{"511.org": &stubPredictor{predictions: []Prediction{
+		{CreatedAt: now, Minutes: 9, RouteCode: "N"},
+		{CreatedAt: now, Minutes: 3, RouteCode: "N_OWL"},
+		{CreatedAt: now, Minutes: 5, RouteCode: "N"},
+	}}})
+	if err := m.refreshPredictionsForStop("judah"); err != nil {
+		t.Fatal(err)
+	}
+
+	var minutes []int
+	for _, p := range m.Current("judah") {
+		minutes = append(minutes, p.Minutes)
+		if !p.DepartsAt.Equal(now.Add(time.Duration(p.Minutes) * time.Minute)) {
+			t.Errorf("got departure time %s for %+v", p.DepartsAt, p)
+		}
+	}
+	if expected := []int{3, 5, 9}; !reflect.DeepEqual(minutes, expected) {
+		t.Errorf("got minutes %v, expected %v", minutes, expected)
+	}
+}
@@ -0,0 +1,73 @@
+package predictions
+
+import "time"
+
+// StopStatus describes how fresh a stop's predictions are and whether its
+// source is failing.
+type StopStatus struct {
+	// LastSuccess is when the stop's predictions were last refreshed.
+	LastSuccess time.Time `json:"last_success"`
+	// LastAttempt is when refreshing the stop's predictions was last tried.
+	LastAttempt         time.Time `json:"last_attempt"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	// Breaker is the state of the stop's circuit breaker: "closed", "open"
+	// or "half-open". While it's not closed, the stop is only refreshed
+	// after RetryAt.
+	Breaker string     `json:"breaker"`
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+	// QuotaInterval is the minimum time between refreshes of the stop that
+	// keeps its access tokens within budget, if it has any.
+	QuotaInterval string `json:"quota_interval,omitempty"`
+	// Idle is true if no client has requested the stop recently, so it's
+	// refreshed less often or not at all.
+	Idle bool `json:"idle"`
+	// ActiveSource is the source that a fallback stop's predictions last
+	// came from.
+	ActiveSource string `json:"active_source,omitempty"`
+}
+
+func (m *Module) recordRefresh(key string, startedAt time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.stopStatuses[key]
+	status.LastAttempt = startedAt
+	m.breakers[key].record(err, time.Now())
+	if err != nil {
+		status.LastError = err.Error()
+		status.ConsecutiveFailures++
+	} else {
+		status.LastSuccess = status.LastAttempt
+		status.LastError = ""
+		status.ConsecutiveFailures = 0
+	}
+	m.stopStatuses[key] = status
+}
+
+// Status returns the refresh status of the stop. Its predictions are the ones
+// from LastSuccess, even if later attempts have failed.
+func (m *Module) Status(stop string) StopStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.stopStatuses[stop]
+	if b, ok := m.breakers[stop]; ok {
+		status.Breaker = b.state
+		if !b.retryAt.IsZero() {
+			retryAt := b.retryAt
+			status.RetryAt = &retryAt
+		}
+	}
+	now := time.Now()
+	if interval := m.quotas.interval(stop, m.localTime(now)); interval > 0 {
+		status.QuotaInterval = interval.String()
+	}
+	status.Idle = m.idle(stop, now)
+	if s, ok := m.stops[stop]; ok {
+		if f, ok := m.predictors[s.source()].(*fallbackPredictor); ok {
+			status.ActiveSource = f.LastSource()
+		}
+	}
+	return status
+}
@@ -0,0 +1,46 @@
+package predictions
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStatusKeepsLastGoodPredictions(t *testing.T) {
+	source := &stubPredictor{predictions: []Prediction{{Minutes: 4, RouteCode: "N"}}}
+	m := newTestModule(t, map[string]Stop{
+		"judah": {Route: "N", Code: 15201, Source: "511.org"},
+	}, map[string]Predictor{"511.org": source})
+	if status := m.Status("judah"); !status.LastAttempt.IsZero() || !status.LastSuccess.IsZero() {
+		t.Fatalf("expected no refreshes yet, got %+v", status)
+	}
+
+	m.refreshStop("judah")
+	good := m.Status("judah")
+	if good.LastSuccess.IsZero() || !good.LastSuccess.Equal(good.LastAttempt) || good.LastError != "" {
+		t.Fatalf("expected a successful refresh, got %+v", good)
+	}
+
+	// Failures are counted without touching the last good predictions.
+	source.err = errors.New("The 511.org API responded with a non-200 status code: 500")
+	for i := 1; i <= 2; i++ {
+		time.Sleep(time.Millisecond)
+		m.refreshStop("judah")
+		status := m.Status("judah")
+		if status.ConsecutiveFailures != i || status.LastError != source.err.Error() {
+			t.Errorf("after failure %d: got %+v", i, status)
+		}
+		if !status.LastSuccess.Equal(good.LastSuccess) || !status.LastAttempt.After(good.LastSuccess) {
+			t.Errorf("after failure %d: expected only the last attempt to move, got %+v", i, status)
+		}
+		if predictions := m.Current("judah"); len(predictions) != 1 || predictions[0].Minutes != 4 {
+			t.Errorf("after failure %d: expected the last good predictions, got %+v", i, predictions)
+		}
+	}
+
+	source.err = nil
+	m.refreshStop("judah")
+	if status := m.Status("judah"); status.ConsecutiveFailures != 0 || status.LastError != "" || !status.LastSuccess.After(good.LastSuccess) {
+		t.Errorf("expected the failures to be cleared, got %+v", status)
+	}
+}